@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	annotationSourceRegistry = "io.registry-sync.source-registry"
+	annotationSourceDigest   = "io.registry-sync.source-digest"
+	annotationSyncTime       = "io.registry-sync.sync-time"
+	annotationToolVersion    = "io.registry-sync.tool-version"
+
+	artifactTypeSyncMetadata = "application/vnd.registry-sync.metadata"
+)
+
+// errHasReferrers is returned instead of re-putting a manifest that has
+// referrers, which would be left attached to the old digest.
+var errHasReferrers = errors.New("manifest has referrers")
+
+// copyAnnotations merges the annotations of the source manifest into the
+// target manifest and re-puts it under the target tag. Pushing through the
+// Docker daemon drops them. Only OCI manifests and indexes carry annotations.
+func (s *syncer) copyAnnotations(ctx context.Context, img *ImageConfig) error {
+	src, err := parseImageRef(img.Source)
+	if err != nil {
+		return err
+	}
+	dst, err := parseImageRef(img.Target)
+	if err != nil {
+		return err
+	}
+	srcInfo, err := s.registry.getManifest(ctx, src)
+	if err != nil {
+		return err
+	}
+	var srcManifest struct {
+		Annotations map[string]string `json:"annotations"`
+	}
+	if e := json.Unmarshal(srcInfo.Body, &srcManifest); e != nil {
+		return fmt.Errorf("decode manifest %s failed: %w", src, e)
+	}
+	if len(srcManifest.Annotations) == 0 {
+		return nil
+	}
+
+	dstInfo, err := s.registry.getManifest(ctx, dst)
+	if err != nil {
+		return err
+	}
+	if !supportsAnnotations(dstInfo) {
+		s.logger.Warn("target manifest does not support annotations", "image", img.Target, "media_type", dstInfo.MediaType)
+		return nil
+	}
+	digest, err := s.annotateManifest(ctx, dst, dstInfo, srcManifest.Annotations)
+	if err != nil || digest == "" {
+		return err
+	}
+	s.logger.Info("copied annotations", "image", img.Target, "annotations", len(srcManifest.Annotations), "digest", digest)
+	return nil
+}
+
+// annotateSyncMetadata records where and when the target was synced from in
+// a referrer artifact attached to it. Registries without the referrers API
+// get the annotations on the target manifest instead, which is only re-put
+// when the source digest changed so the target digest stays stable.
+func (s *syncer) annotateSyncMetadata(ctx context.Context, img *ImageConfig) error {
+	src, err := parseImageRef(img.Source)
+	if err != nil {
+		return err
+	}
+	dst, err := parseImageRef(img.Target)
+	if err != nil {
+		return err
+	}
+	srcInfo, found, err := s.registry.headManifest(ctx, src)
+	if err != nil {
+		return err
+	}
+	annotations := map[string]string{
+		annotationSourceRegistry: src.Domain,
+		annotationSyncTime:       time.Now().UTC().Format(time.RFC3339),
+		annotationToolVersion:    BuildVersion,
+	}
+	if found {
+		annotations[annotationSourceDigest] = srcInfo.Digest
+	}
+
+	dstInfo, err := s.registry.getManifest(ctx, dst)
+	if err != nil {
+		return err
+	}
+	var pushed string
+	existing, err := s.registry.referrers(ctx, dst, digest.FromBytes(dstInfo.Body).String(), artifactTypeSyncMetadata)
+	switch {
+	case err == nil:
+		for _, desc := range existing {
+			if found && desc.ArtifactType == artifactTypeSyncMetadata && desc.Annotations[annotationSourceDigest] == srcInfo.Digest {
+				s.logger.Debug("sync metadata already recorded", "image", img.Target, "source_digest", srcInfo.Digest)
+				return nil
+			}
+		}
+		pushed, err = s.putMetadataReferrer(ctx, dst, dstInfo, annotations)
+	case supportsAnnotations(dstInfo):
+		s.logger.Debug("referrers not supported, annotating the target manifest", "image", img.Target, "error", err)
+		if found && manifestAnnotations(dstInfo)[annotationSourceDigest] == srcInfo.Digest {
+			s.logger.Debug("sync metadata already recorded", "image", img.Target, "source_digest", srcInfo.Digest)
+			return nil
+		}
+		pushed, err = s.annotateManifest(ctx, dst, dstInfo, annotations)
+	default:
+		return fmt.Errorf("%s supports neither referrers nor annotations: %w", dst, err)
+	}
+	if err != nil {
+		return err
+	}
+	s.logger.Info("annotated sync metadata", "image", img.Target, "digest", pushed)
+	return nil
+}
+
+// manifestAnnotations returns the annotations of a manifest or index, nil
+// when it has none or cannot be decoded.
+func manifestAnnotations(info *manifestInfo) map[string]string {
+	var manifest struct {
+		Annotations map[string]string `json:"annotations"`
+	}
+	_ = json.Unmarshal(info.Body, &manifest)
+	return manifest.Annotations
+}
+
+func supportsAnnotations(info *manifestInfo) bool {
+	return info.MediaType == ocispec.MediaTypeImageManifest || info.MediaType == ocispec.MediaTypeImageIndex
+}
+
+// annotateManifest merges annotations into the manifest info of dst and
+// re-puts it, returning the new digest or "" when nothing changed. It returns
+// errHasReferrers without changing anything when the manifest has referrers.
+func (s *syncer) annotateManifest(ctx context.Context, dst *imageRef, info *manifestInfo, add map[string]string) (string, error) {
+	var manifest map[string]json.RawMessage
+	if e := json.Unmarshal(info.Body, &manifest); e != nil {
+		return "", fmt.Errorf("decode manifest %s failed: %w", dst, e)
+	}
+	annotations := make(map[string]string)
+	if raw, ok := manifest["annotations"]; ok {
+		if e := json.Unmarshal(raw, &annotations); e != nil {
+			return "", fmt.Errorf("decode annotations of %s failed: %w", dst, e)
+		}
+	}
+	changed := false
+	for k, v := range add {
+		if annotations[k] != v {
+			annotations[k] = v
+			changed = true
+		}
+	}
+	if !changed {
+		return "", nil
+	}
+	if referrers, e := s.registry.referrers(ctx, dst, digest.FromBytes(info.Body).String(), ""); e != nil {
+		s.logger.Debug("list referrers failed", "image", dst.String(), "error", e)
+	} else if len(referrers) > 0 {
+		return "", fmt.Errorf("re-put %s would orphan %d referrers: %w", dst, len(referrers), errHasReferrers)
+	}
+	raw, err := json.Marshal(annotations)
+	if err != nil {
+		return "", err
+	}
+	manifest["annotations"] = raw
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	return s.registry.putManifest(ctx, dst, info.MediaType, body)
+}
+
+// putMetadataReferrer pushes an empty OCI artifact carrying annotations whose
+// subject is the manifest info of dst.
+func (s *syncer) putMetadataReferrer(ctx context.Context, dst *imageRef, info *manifestInfo, annotations map[string]string) (string, error) {
+	empty := ocispec.DescriptorEmptyJSON
+	if exists, err := s.registry.blobExists(ctx, dst, empty.Digest.String()); err != nil {
+		return "", err
+	} else if !exists {
+		if e := s.registry.uploadBlob(ctx, dst, empty.Digest.String(), empty.Size, bytes.NewReader(empty.Data)); e != nil {
+			return "", e
+		}
+	}
+	manifest := ocispec.Manifest{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: artifactTypeSyncMetadata,
+		Config:       empty,
+		Layers:       []ocispec.Descriptor{empty},
+		Subject: &ocispec.Descriptor{
+			MediaType: info.MediaType,
+			Digest:    digest.FromBytes(info.Body),
+			Size:      int64(len(info.Body)),
+		},
+		Annotations: annotations,
+	}
+	manifest.SchemaVersion = 2
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	return s.registry.putManifest(ctx, dst.withReference(digest.FromBytes(body).String()), ocispec.MediaTypeImageManifest, body)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// schemeTar marks a target that is saved to a Docker image archive instead
+// of being pushed, e.g. tar://images/offline.tar.
+const schemeTar = "tar://"
+
+func archivePath(target string) (string, bool) {
+	return strings.CutPrefix(target, schemeTar)
+}
+
+// archiveQueue collects the images pulled for each archive during a cycle.
+type archiveQueue struct {
+	mu   sync.Mutex
+	refs map[string][]string
+}
+
+func (q *archiveQueue) add(path, ref string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.refs == nil {
+		q.refs = make(map[string][]string)
+	}
+	q.refs[path] = append(q.refs[path], ref)
+}
+
+// saveArchives writes the queued images to their archives, appending them to
+// archives written before.
+func (s *syncer) saveArchives(ctx context.Context) error {
+	s.archives.mu.Lock()
+	queued := s.archives.refs
+	s.archives.refs = nil
+	s.archives.mu.Unlock()
+
+	var errs []error
+	for path, refs := range queued {
+		start := time.Now()
+		e := saveArchive(ctx, s.cli, path, refs, s.bandwidth)
+		s.audit.record("save", path, start, e)
+		if e != nil {
+			errs = append(errs, fmt.Errorf("save archive %s failed: %w", path, e))
+			continue
+		}
+		s.logger.Info("save archive success", "path", path, "images", len(refs))
+		if s.config.CleanupAfterSync {
+			s.removeLocalImages(refs...)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func saveArchive(ctx context.Context, cli *client.Client, path string, refs []string, bandwidth *bandwidthLimiter) error {
+	saved, err := cli.ImageSave(ctx, refs)
+	if err != nil {
+		return err
+	}
+	defer saved.Close()
+	if dir := filepath.Dir(path); dir != "." {
+		if e := os.MkdirAll(dir, 0o755); e != nil {
+			return e
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	throttled := bandwidth.reader(ctx, saved)
+	archives := []io.Reader{throttled}
+	if existing, e := os.Open(path); e == nil {
+		defer existing.Close()
+		archives = []io.Reader{existing, throttled}
+	} else if !errors.Is(e, os.ErrNotExist) {
+		_ = tmp.Close()
+		return e
+	}
+	if e := mergeArchives(tmp, archives...); e != nil {
+		_ = tmp.Close()
+		return e
+	}
+	if e := tmp.Close(); e != nil {
+		return e
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// mergeArchives writes one Docker image archive holding the images of all
+// archives. Layers and configs are content addressed, so a file already
+// written is skipped, while the manifest.json, repositories and index.json
+// entries of later archives are added after those of earlier ones.
+func mergeArchives(w io.Writer, archives ...io.Reader) error {
+	tw := tar.NewWriter(w)
+	seen := make(map[string]bool)
+	var manifests []map[string]any
+	seenConfigs := make(map[string]bool)
+	repositories := make(map[string]map[string]string)
+	var index *ocispec.Index
+	seenDigests := make(map[string]bool)
+
+	for _, archive := range archives {
+		tr := tar.NewReader(archive)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			switch hdr.Name {
+			case "manifest.json":
+				var entries []map[string]any
+				if e := json.NewDecoder(tr).Decode(&entries); e != nil {
+					return fmt.Errorf("invalid manifest.json: %w", e)
+				}
+				for _, entry := range entries {
+					config, _ := entry["Config"].(string)
+					if !seenConfigs[config] {
+						seenConfigs[config] = true
+						manifests = append(manifests, entry)
+					}
+				}
+			case "repositories":
+				var repos map[string]map[string]string
+				if e := json.NewDecoder(tr).Decode(&repos); e != nil {
+					return fmt.Errorf("invalid repositories: %w", e)
+				}
+				for repo, tags := range repos {
+					if repositories[repo] == nil {
+						repositories[repo] = make(map[string]string)
+					}
+					for tag, id := range tags {
+						repositories[repo][tag] = id
+					}
+				}
+			case "index.json":
+				var idx ocispec.Index
+				if e := json.NewDecoder(tr).Decode(&idx); e != nil {
+					return fmt.Errorf("invalid index.json: %w", e)
+				}
+				if index == nil {
+					index = &ocispec.Index{Versioned: idx.Versioned, MediaType: idx.MediaType}
+				}
+				for _, m := range idx.Manifests {
+					if !seenDigests[m.Digest.String()] {
+						seenDigests[m.Digest.String()] = true
+						index.Manifests = append(index.Manifests, m)
+					}
+				}
+			default:
+				if seen[hdr.Name] {
+					continue
+				}
+				seen[hdr.Name] = true
+				if e := tw.WriteHeader(hdr); e != nil {
+					return e
+				}
+				if _, e := io.Copy(tw, tr); e != nil {
+					return e
+				}
+			}
+		}
+	}
+
+	files := map[string]any{"manifest.json": manifests}
+	if len(repositories) > 0 {
+		files["repositories"] = repositories
+	}
+	if index != nil {
+		files["index.json"] = index
+	}
+	for _, name := range []string{"index.json", "manifest.json", "repositories"} {
+		v, ok := files[name]
+		if !ok {
+			continue
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if e := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}); e != nil {
+			return e
+		}
+		if _, e := tw.Write(data); e != nil {
+			return e
+		}
+	}
+	return tw.Close()
+}
+
+// loadArchive loads the images of a Docker image archive into the daemon.
+func loadArchive(ctx context.Context, cli *client.Client, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	resp, err := cli.ImageLoad(ctx, f, true)
+	if err != nil {
+		return fmt.Errorf("load archive %s failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if !resp.JSON {
+		return readAllToDiscard(resp.Body)
+	}
+	if e := readJSONMessages(resp.Body, nil); e != nil {
+		return fmt.Errorf("load archive %s failed: %w", path, e)
+	}
+	return nil
+}
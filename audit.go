@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+type AuditEntry struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Operation  string    `json:"operation"`
+	Image      string    `json:"image"`
+	Outcome    string    `json:"outcome"`
+	DurationMs int64     `json:"duration_ms"`
+	Operator   string    `json:"operator,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type auditLogger struct {
+	mu       sync.Mutex
+	path     string
+	writer   io.WriteCloser
+	operator string
+	logger   *slog.Logger
+}
+
+func newAuditLogger(path string, logger *slog.Logger) *auditLogger {
+	if path == "" {
+		return nil
+	}
+	return &auditLogger{
+		path: path,
+		writer: &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    100,
+			MaxBackups: 5,
+			Compress:   true,
+		},
+		operator: os.Getenv("SYNC_OPERATOR"),
+		logger:   logger,
+	}
+}
+
+// record appends an entry to the audit log. It is safe to call on a nil logger.
+func (a *auditLogger) record(operation, image string, start time.Time, err error) {
+	if a == nil {
+		return
+	}
+	entry := AuditEntry{
+		Timestamp:  start,
+		Operation:  operation,
+		Image:      image,
+		Outcome:    "success",
+		DurationMs: time.Since(start).Milliseconds(),
+		Operator:   a.operator,
+	}
+	if err != nil {
+		entry.Outcome = "failure"
+		entry.Error = err.Error()
+	}
+	data, e := json.Marshal(entry)
+	if e != nil {
+		a.logger.Error("Failed to encode audit entry", "error", e)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, e = a.writer.Write(append(data, '\n')); e != nil {
+		a.logger.Error("Failed to write audit log", "path", a.path, "error", e)
+	}
+}
+
+func (a *auditLogger) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.writer.Close()
+}
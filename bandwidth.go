@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	bytesPerMB            = 1 << 20
+	throughputLogInterval = 10 * time.Second
+)
+
+// bandwidthLimiter caps the combined throughput of the streams read through
+// it and logs the throughput while they transfer data. Daemon and containerd
+// pulls and pushes move their data outside this process, so it only covers
+// registry blob copies and archive saves.
+type bandwidthLimiter struct {
+	limiter *rate.Limiter
+	logger  *slog.Logger
+
+	mu          sync.Mutex
+	bytes       int64
+	windowStart time.Time
+}
+
+func newBandwidthLimiter(mbps float64, logger *slog.Logger) *bandwidthLimiter {
+	if mbps <= 0 {
+		return nil
+	}
+	limit := mbps * bytesPerMB
+	burst := max(int(limit), 32*1024)
+	return &bandwidthLimiter{
+		limiter:     rate.NewLimiter(rate.Limit(limit), burst),
+		logger:      logger,
+		windowStart: time.Now(),
+	}
+}
+
+// reader returns r throttled by the limiter. It is safe to call on a nil
+// limiter.
+func (b *bandwidthLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if b == nil {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, b: b}
+}
+
+// record adds n transferred bytes, logging the throughput once per interval.
+func (b *bandwidthLimiter) record(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bytes += int64(n)
+	if elapsed := time.Since(b.windowStart); elapsed >= throughputLogInterval {
+		b.logger.Info("Copy throughput", "mbps", float64(b.bytes)/bytesPerMB/elapsed.Seconds(), "limit_mbps", float64(b.limiter.Limit())/bytesPerMB)
+		b.bytes, b.windowStart = 0, time.Now()
+	}
+}
+
+type throttledReader struct {
+	ctx context.Context
+	r   io.Reader
+	b   *bandwidthLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if burst := t.b.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if e := t.b.limiter.WaitN(t.ctx, n); e != nil {
+			return n, e
+		}
+		t.b.record(n)
+	}
+	return n, err
+}
+
+// throttle applies MaxCopyBandwidthMBps to the registry client and archive
+// saves, warning when configured images transfer through a runtime it cannot
+// limit.
+func (s *syncer) throttle() {
+	s.bandwidth = newBandwidthLimiter(s.config.MaxCopyBandwidthMBps, s.logger)
+	s.registry.bandwidth = s.bandwidth
+	if s.bandwidth == nil {
+		return
+	}
+	unthrottled := 0
+	for _, img := range s.config.Images {
+		if s.containerd != nil || !(img.DeltaSync || img.NormalizeManifest) {
+			unthrottled++
+		}
+	}
+	if unthrottled > 0 {
+		s.logger.Warn("max_copy_bandwidth_mbps does not limit daemon or containerd pulls and pushes", "images", unthrottled, "total", len(s.config.Images))
+	}
+}
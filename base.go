@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sort"
+)
+
+// orderByBase moves the base images in front of the images built on them
+// and returns how many bases it moved. An image is the base of another when
+// the other has all of its layers and more. Ordering the bases by their
+// layer count is therefore a topological order of the dependency graph.
+func (s *syncer) orderByBase(ctx context.Context, images []ImageConfig) ([]ImageConfig, int) {
+	layers := make([]map[string]bool, len(images))
+	for i, img := range images {
+		ref, err := parseImageRef(img.Source)
+		if err != nil {
+			continue
+		}
+		manifest, err := s.manifestLayers(ctx, ref)
+		if err != nil {
+			s.logger.Debug("Failed to read manifest for base ordering", "image", img.Source, "error", err)
+			continue
+		}
+		layers[i] = make(map[string]bool, len(manifest.Layers))
+		for _, layer := range manifest.Layers {
+			layers[i][layer.Digest] = true
+		}
+	}
+
+	isBase := make([]bool, len(images))
+	for i := range images {
+		for j := range images {
+			if i != j && isBaseOf(layers[i], layers[j]) {
+				isBase[i] = true
+				break
+			}
+		}
+	}
+
+	var bases, rest []int
+	for i := range images {
+		if isBase[i] {
+			bases = append(bases, i)
+		} else {
+			rest = append(rest, i)
+		}
+	}
+	sort.SliceStable(bases, func(a, b int) bool {
+		return len(layers[bases[a]]) < len(layers[bases[b]])
+	})
+	ordered := make([]ImageConfig, 0, len(images))
+	for _, i := range append(bases, rest...) {
+		ordered = append(ordered, images[i])
+	}
+	if len(bases) > 0 {
+		s.logger.Info("Syncing base images first", "bases", len(bases))
+	}
+	return ordered, len(bases)
+}
+
+// isBaseOf reports whether derived has every layer of base and more.
+func isBaseOf(base, derived map[string]bool) bool {
+	if len(base) == 0 || len(base) >= len(derived) {
+		return false
+	}
+	for digest := range base {
+		if !derived[digest] {
+			return false
+		}
+	}
+	return true
+}
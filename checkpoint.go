@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// checkpoint records the images synced so far in a cycle, so a cycle
+// interrupted by a crash or kill resumes without syncing them again. It is
+// removed when the cycle ends. All methods are safe on a nil checkpoint.
+type checkpoint struct {
+	mu   sync.Mutex
+	path string
+
+	StartedAt time.Time `json:"started_at"`
+	Completed []string  `json:"completed"`
+	done      map[string]bool
+}
+
+func checkpointKey(img *ImageConfig) string {
+	return img.Source + " -> " + img.Target
+}
+
+// loadCheckpoint reads the checkpoint left by an interrupted cycle, or starts
+// a new one when there is none.
+func loadCheckpoint(path string) (*checkpoint, error) {
+	c := &checkpoint{path: path, StartedAt: time.Now(), done: make(map[string]bool)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	if e := json.Unmarshal(data, c); e != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint: %w", e)
+	}
+	for _, key := range c.Completed {
+		c.done[key] = true
+	}
+	return c, nil
+}
+
+func (c *checkpoint) contains(img *ImageConfig) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done[checkpointKey(img)]
+}
+
+// add records img as synced and writes the checkpoint.
+func (c *checkpoint) add(img *ImageConfig) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := checkpointKey(img)
+	if c.done[key] {
+		return nil
+	}
+	c.done[key] = true
+	c.Completed = append(c.Completed, key)
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if e := writeFileAtomic(c.path, data); e != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", e)
+	}
+	return nil
+}
+
+func (c *checkpoint) clear() error {
+	if c == nil {
+		return nil
+	}
+	if e := os.Remove(c.path); e != nil && !errors.Is(e, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove checkpoint: %w", e)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// cleanupRegistry deletes tags from the target repositories that no longer
+// exist at the source. Tags configured as a target are always kept. With
+// dryRun set it only logs what would be deleted.
+func (s *syncer) cleanupRegistry(ctx context.Context, dryRun bool) error {
+	keep := make(map[string]map[string]bool)
+	var order []string
+	sourceTags := make(map[string][]string)
+	for _, img := range s.resolvedImages(ctx) {
+		targetRepo, targetTag := splitTag(img.Target)
+		if keep[targetRepo] == nil {
+			keep[targetRepo] = make(map[string]bool)
+			order = append(order, targetRepo)
+		}
+		keep[targetRepo][targetTag] = true
+		tags := img.Tags
+		if len(tags) == 0 {
+			sourceRepo, _ := splitTag(img.Source)
+			if _, ok := sourceTags[sourceRepo]; !ok {
+				ref, err := parseImageRef(sourceRepo)
+				if err != nil {
+					return err
+				}
+				listed, err := s.registry.listTags(ctx, ref)
+				if err != nil {
+					return fmt.Errorf("list source tags of %s failed: %w", sourceRepo, err)
+				}
+				sourceTags[sourceRepo] = listed
+			}
+			tags = sourceTags[sourceRepo]
+		}
+		for _, tag := range tags {
+			keep[targetRepo][img.TargetTagPrefix+tag+img.TargetTagSuffix] = true
+		}
+	}
+
+	var errs []error
+	for _, repo := range order {
+		if err := s.cleanupRepository(ctx, repo, keep[repo], dryRun); err != nil {
+			s.logger.Error("Error cleaning up repository", "repository", repo, "error", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *syncer) cleanupRepository(ctx context.Context, repo string, keep map[string]bool, dryRun bool) error {
+	ref, err := parseImageRef(repo)
+	if err != nil {
+		return err
+	}
+	tags, err := s.registry.listTags(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("list target tags of %s failed: %w", repo, err)
+	}
+	sort.Strings(tags)
+
+	var stale []string
+	for _, tag := range tags {
+		if !keep[tag] {
+			stale = append(stale, tag)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	// Deleting a manifest removes every tag pointing to it, so stale tags
+	// sharing a digest with a kept tag are left alone.
+	kept, err := s.taggedDigests(ctx, ref, tags, func(tag string) bool { return keep[tag] })
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range stale {
+		target := ref.withReference(tag)
+		info, ok, e := s.registry.headManifest(ctx, target)
+		if e != nil {
+			return e
+		}
+		if !ok {
+			continue
+		}
+		if kept[info.Digest] {
+			s.logger.Info("Keeping tag, its digest is still tagged by a synced tag", "image", target.String(), "digest", info.Digest)
+			continue
+		}
+		if dryRun {
+			s.logger.Info("[dry-run] would delete", "image", target.String(), "digest", info.Digest)
+			continue
+		}
+		start := time.Now()
+		e = s.registry.deleteManifest(ctx, target, info.Digest)
+		s.audit.record("delete", target.String(), start, e)
+		if e != nil {
+			return e
+		}
+		s.logger.Info("deleted", "image", target.String(), "digest", info.Digest)
+	}
+	return nil
+}
+
+// taggedDigests returns the digests the tags of ref selected by include
+// point to.
+func (s *syncer) taggedDigests(ctx context.Context, ref *imageRef, tags []string, include func(string) bool) (map[string]bool, error) {
+	digests := make(map[string]bool)
+	for _, tag := range tags {
+		if !include(tag) {
+			continue
+		}
+		info, ok, err := s.registry.headManifest(ctx, ref.withReference(tag))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			digests[info.Digest] = true
+		}
+	}
+	return digests, nil
+}
@@ -1,27 +1,144 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/docker/docker/api/types/registry"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strings"
+	"time"
 )
 
 type RegistryAuth struct {
-	Auth     string `json:"auth"`
+	Auth     string `json:"auth" jsonschema_description:"Base64 encoded Docker auth config, takes precedence over username and password"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+
+	PushRateLimit *PushRateLimit `json:"push_rate_limit,omitempty" jsonschema_description:"Throttles pushes to this registry"`
+
+	// Insecure talks plain HTTP to the registry API. The Docker daemon must
+	// also list the registry in its "insecure-registries" setting.
+	Insecure bool `json:"insecure,omitempty"`
 }
 
 type ImageConfig struct {
-	Source string `json:"source"`
-	Target string `json:"target"`
+	Source      string `json:"source" jsonschema_description:"Image to pull, the tag may be a glob"`
+	Target      string `json:"target" jsonschema_description:"Image to push, may contain the {{source}} placeholder"`
+	PreSyncHook string `json:"pre_sync_hook" jsonschema_description:"Shell command run before syncing, a non-zero exit skips the image"`
+	Transform   string `json:"transform" jsonschema_description:"Shell command run on the local target image $IMAGE before pushing, a non-zero exit fails the image"`
+
+	SyncAttestations bool `json:"sync_attestations" jsonschema_description:"Copy in-toto attestations referring to the source image"`
+	DeltaSync        bool `json:"delta_sync" jsonschema_description:"Copy only missing layers through the registry API instead of the Docker daemon, not combinable with transform or -image-filter"`
+
+	// SyncReferrers copies the OCI referrers of the source image, such as
+	// signatures and SBOMs, limited to ReferrerTypes artifact types when set.
+	SyncReferrers bool     `json:"sync_referrers"`
+	ReferrerTypes []string `json:"referrer_types"`
+
+	// PullPolicy is "always" (default) or "if-not-present".
+	PullPolicy string `json:"pull_policy" jsonschema:"enum=,enum=always,enum=if-not-present"`
+
+	// PullMode "lazy" registers the image with Config.LazySnapshotter, which
+	// fetches eStargz or Nydus layers on demand, instead of downloading them.
+	// It requires runtime "containerd" and copies the image to the target
+	// between the registries.
+	PullMode string `json:"pull_mode" jsonschema:"enum=,enum=lazy"`
+
+	// WarmUp pulls the image on Config.WarmUpSchedule without pushing it,
+	// and syncs it with the "if-not-present" pull policy.
+	WarmUp bool `json:"warm_up"`
+
+	// TagSortOrder orders the tags matched by a glob in the source tag:
+	// "alpha" (default), "semver" or "date", newest first for the latter two.
+	TagSortOrder string `json:"tag_sort_order" jsonschema:"enum=,enum=alpha,enum=semver,enum=date"`
+	MaxImages    int    `json:"max_images" jsonschema:"minimum=0" jsonschema_description:"Maximum number of tags a glob expands to, 0 for no limit"`
+
+	GroupBy string `json:"group_by" jsonschema_description:"Group name selected with -group"`
+
+	// ImmutableTags are glob patterns of target tags that must never be overwritten.
+	ImmutableTags []string `json:"immutable_tags"`
+
+	// MaxRetryDuration overrides Config.MaxRetryDuration for this image.
+	MaxRetryDuration string `json:"max_retry_duration"`
+
+	// GHCROrg or GHCRUser mirrors every container package of that GitHub
+	// owner below Target; Source is ignored.
+	GHCROrg   string `json:"ghcr_org"`
+	GHCRUser  string `json:"ghcr_user"`
+	GHCRToken string `json:"ghcr_token"`
+
+	// NotBefore and NotAfter limit syncing to a daily HH:MM window in Config.Timezone.
+	NotBefore string `json:"not_before" jsonschema:"pattern=^([0-9]{2}:[0-9]{2})?$"`
+	NotAfter  string `json:"not_after" jsonschema:"pattern=^([0-9]{2}:[0-9]{2})?$"`
+
+	// ExpiresAt is an RFC3339 time after which the image is no longer
+	// synced, e.g. for release candidates. DeleteExpired also deletes the
+	// target tag once it expired.
+	ExpiresAt     string `json:"expires_at" jsonschema:"format=date-time"`
+	DeleteExpired bool   `json:"delete_expired"`
+
+	VerifyPushDigest bool `json:"verify_push_digest" jsonschema_description:"Check the pushed digest against the target registry and retry on mismatch"`
+
+	// CopyAnnotations copies the OCI annotations of the source manifest to the target after pushing.
+	CopyAnnotations bool `json:"copy_annotations"`
+
+	// AnnotateWithSyncMetadata records the source registry and digest, the
+	// sync time and the tool version of the target in a referrer artifact,
+	// or in the target manifest without a referrers API, once per source
+	// digest.
+	AnnotateWithSyncMetadata bool `json:"annotate_with_sync_metadata"`
+
+	// TagWithDigest also tags the pushed target as <repo>:sha256-<digest>,
+	// an immutable reference next to the floating tag.
+	TagWithDigest bool `json:"tag_with_digest"`
+
+	// Tags are kept at the target by -cleanup-registry instead of the source tag list.
+	Tags []string `json:"tags"`
+
+	// NormalizeManifest converts Docker manifests to OCI, for registries
+	// rejecting the pushed format. With runtime containerd the pulled image
+	// is converted in the content store before pushing. The Docker daemon
+	// cannot store converted manifests, so other runtimes copy the image
+	// through the registry API like delta_sync, converting it on the way.
+	NormalizeManifest bool `json:"normalize_manifest"`
+
+	// TargetTagPrefix and TargetTagSuffix are added to the target tag, e.g.
+	// "mirror-" or "-internal".
+	TargetTagPrefix string `json:"target_tag_prefix"`
+	TargetTagSuffix string `json:"target_tag_suffix"`
+
+	// MaxPullSize overrides Config.MaxPullSize for this image.
+	MaxPullSize int64 `json:"max_pull_size" jsonschema:"minimum=0"`
+
+	// MaxSizeIncreasePct warns when the compressed size of the pulled image
+	// grew by more percent than the size in the state file, or rejects the
+	// image in strict mode.
+	MaxSizeIncreasePct float64 `json:"max_size_increase_pct" jsonschema:"minimum=0"`
+
+	// PullAuthEnv and PushAuthEnv name environment variables holding a
+	// base64 "user:password" auth that overrides auths for the daemon pull or
+	// push of this image.
+	PullAuthEnv string `json:"pull_auth_env"`
+	PushAuthEnv string `json:"push_auth_env"`
+
+	// SourceMirrors are registries serving the same repositories as the
+	// source registry. Healthy mirrors are tried in order, starting with the
+	// one that last succeeded, before the source registry itself.
+	SourceMirrors []string `json:"source_mirrors"`
+
+	// OnError is what a failure of this image does to the images not yet
+	// started in the cycle: "continue" (default), "stop" all of them or
+	// "skip-registry" those from the same source registry.
+	OnError string `json:"on_error" jsonschema:"enum=,enum=continue,enum=stop,enum=skip-registry"`
 }
 
 type ImageWebhook struct {
@@ -29,28 +146,360 @@ type ImageWebhook struct {
 	Method string `json:"method"`
 }
 
+// DockerAuth is an entry of the auths in ~/.docker/config.json.
+type DockerAuth struct {
+	Auth string `json:"auth"`
+	// CredentialProcess is a command printing {"Username": "...", "Secret": "..."}.
+	CredentialProcess string `json:"credentialProcess"`
+}
+
 type DockerConfig struct {
-	Auths map[string]RegistryAuth `json:"auths"`
+	Auths map[string]DockerAuth `json:"auths"`
+	// CredsStore and CredHelpers name docker-credential-* helpers, such as
+	// osxkeychain, holding the credentials of entries without an auth.
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
 }
 
 type Config struct {
-	Images       []ImageConfig           `json:"images"`
-	Auths        map[string]RegistryAuth `json:"auths"`
-	Duration     int                     `json:"duration"`
-	DisablePrune bool                    `json:"disable_prune"`
+	Images           []ImageConfig           `json:"images"`
+	Auths            map[string]RegistryAuth `json:"auths" jsonschema_description:"Credentials keyed by registry or repository prefix"`
+	Duration         int                     `json:"duration" jsonschema:"minimum=0" jsonschema_description:"Seconds to sleep between sync cycles"`
+	DisablePrune     bool                    `json:"disable_prune" jsonschema_description:"Keep unused local images after each cycle"`
+	KeepUntagged     bool                    `json:"keep_untagged" jsonschema_description:"Never remove untagged images when pruning"`
+	PruneProtect     []string                `json:"prune_protect" jsonschema_description:"Glob patterns of image tags or digests never removed when pruning"`
+	AuditLogFile     string                  `json:"audit_log_file" jsonschema_description:"Path of the JSON lines audit log"`
+	CleanupAfterSync bool                    `json:"cleanup_after_sync" jsonschema_description:"Remove the local source and target images after each sync"`
+	UserAgent        string                  `json:"user_agent"`
+
+	NamespaceMappings []NamespaceMapping `json:"namespace_mappings" jsonschema_description:"Prefix rewrites applied to targets using the {{source}} placeholder"`
+	InfluxDB          *InfluxDBConfig    `json:"influxdb"`
+	SQLiteMetricsDB   string             `json:"sqlite_metrics_db" jsonschema_description:"Path of a SQLite database recording the history of every cycle and image"`
+	Notify            *NotifyConfig      `json:"notify" jsonschema_description:"Webhook notified about failed and recovered images"`
+	RejectSchemaV1    bool               `json:"reject_schema_v1" jsonschema_description:"Fail images only available as schema v1 instead of warning"`
+	StrictMode        bool               `json:"strict_mode" jsonschema_description:"Fail the cycle when a warning is logged and fail images on warnings such as schema v1 manifests"`
+
+	RefreshAuthEachCycle bool `json:"refresh_auth_each_cycle" jsonschema_description:"Reload the config before every cycle instead of after it"`
+	Concurrency          int  `json:"concurrency" jsonschema:"minimum=1" jsonschema_description:"Number of images synced in parallel"`
+
+	// ConcurrencyPerRegistry caps the concurrent pulls from a single source
+	// registry below Concurrency. Zero means no cap.
+	ConcurrencyPerRegistry int `json:"concurrency_per_registry" jsonschema:"minimum=0"`
+
+	// MaxCopyBandwidthMBps caps, in MB per second, the data this process
+	// copies itself: the registry to registry copies of delta_sync and
+	// normalize_manifest and tar:// archive saves. Pulls and pushes through
+	// the Docker daemon or containerd are not limited, and a warning reports
+	// how many images use them.
+	MaxCopyBandwidthMBps float64 `json:"max_copy_bandwidth_mbps" jsonschema:"minimum=0"`
+
+	// Extends is a base config, a local path or URL resolved relative to
+	// this config, that this config is deep-merged over.
+	Extends string `json:"extends"`
+
+	StateFile string `json:"state_file" jsonschema_description:"Path of the JSON file recording the last sync of each image"`
+
+	// Etcd reads the config from <prefix>/config and keeps the sync state
+	// under <prefix>/state/ instead of state_file.
+	Etcd *EtcdConfig `json:"etcd,omitempty"`
+
+	// DistributedLock lets replicas skip images another replica is syncing.
+	DistributedLock *DistributedLock `json:"distributed_lock,omitempty"`
+
+	// SmokeTestTarget is where -smoke-test pushes its test image, e.g.
+	// "registry.example.com/smoke/hello-world:latest".
+	SmokeTestTarget string `json:"smoke_test_target"`
+
+	// CheckpointFile records the images synced during a cycle, so a cycle
+	// interrupted by a crash or kill resumes where it stopped.
+	CheckpointFile string `json:"checkpoint_file"`
+
+	RollingUpdate      bool   `json:"rolling_update" jsonschema_description:"Sync images one at a time"`
+	DelayBetweenImages string `json:"delay_between_images" jsonschema_description:"Go duration to wait between images in a rolling update"`
+
+	// WatchConfigInterval is the Go duration between checks of a remote HTTP
+	// config for changes, which are picked up without waiting for the cycle.
+	WatchConfigInterval string `json:"watch_config_interval"`
+
+	// Runtime is "docker" (default), "podman", which connects to the Podman
+	// socket of the current user unless DOCKER_HOST or DockerContextName is
+	// set, or "containerd" to sync through the containerd image store of a
+	// Kubernetes node.
+	Runtime string `json:"runtime" jsonschema:"enum=,enum=docker,enum=podman,enum=containerd"`
+
+	// ContainerdAddress and ContainerdNamespace select the containerd socket
+	// and namespace, by default /run/containerd/containerd.sock and "k8s.io"
+	// so pulled images are visible to the kubelet.
+	ContainerdAddress   string `json:"containerd_address"`
+	ContainerdNamespace string `json:"containerd_namespace"`
+
+	// LazySnapshotter is the containerd remote snapshotter used by images
+	// with pull_mode "lazy", "stargz" (default) or "nydus".
+	LazySnapshotter string `json:"lazy_snapshotter" jsonschema:"enum=,enum=stargz,enum=nydus"`
+
+	// DockerContextName selects the daemon of a Docker CLI context instead of
+	// the one from the environment.
+	DockerContextName string `json:"docker_context_name"`
+
+	DockerDialTimeout     string `json:"docker_dial_timeout"`
+	DockerResponseTimeout string `json:"docker_response_timeout"`
+
+	// SyncOrder lists image sources synced one after another, in this order,
+	// before the remaining images are synced concurrently.
+	SyncOrder []string `json:"sync_order"`
+
+	// AutoOrderByBase syncs images that other images are built on, detected
+	// from their shared layers, one after another before the rest, so bases
+	// reach the target before the images depending on them.
+	AutoOrderByBase bool `json:"auto_order_by_base"`
+
+	// ValidateTargetsOnStart checks that every target accepts pushes before
+	// the first cycle when running as a daemon, like -validate-targets.
+	ValidateTargetsOnStart bool `json:"validate_targets_on_start"`
+
+	// WarmUpSchedule is a cron expression, e.g. "0 3 * * *", at which images
+	// with warm_up are pulled between sync cycles.
+	WarmUpSchedule string `json:"warm_up_schedule"`
+
+	// PrioritySource is a JSON or Prometheus query URL returning image pull
+	// counts. The most pulled images are synced first.
+	PrioritySource string `json:"priority_source"`
+
+	// MaxRetries and MaxRetryDuration bound how often and for how long a
+	// failed image is retried with exponential backoff within a cycle.
+	MaxRetries       int    `json:"max_retries" jsonschema:"minimum=0"`
+	MaxRetryDuration string `json:"max_retry_duration"`
+
+	// RequeueMaxAttempts is how many times images still failing after their
+	// retries are synced again at the end of a cycle.
+	RequeueMaxAttempts int `json:"requeue_max_attempts" jsonschema:"minimum=0"`
+
+	// MaxCycleErrors exits the process after that many consecutive cycles
+	// with errors, so a supervisor restarts it with a fresh config.
+	MaxCycleErrors int `json:"max_cycle_errors" jsonschema:"minimum=0"`
+
+	// ErrorSampleRate is the fraction of repeated identical image errors that
+	// are logged. The first occurrence and changed errors are always logged;
+	// 0 (default) logs every error.
+	ErrorSampleRate float64 `json:"error_sample_rate" jsonschema:"minimum=0,maximum=1"`
+
+	// TransferTimeout is a Go duration bounding the wall-clock time of a sync cycle.
+	TransferTimeout string `json:"transfer_timeout"`
+
+	// Timezone is an IANA time zone name used for sync windows, local time by default.
+	Timezone string `json:"timezone"`
+
+	// RegistryMirror is a pull-through cache used instead of Docker Hub when pulling.
+	RegistryMirror string `json:"registry_mirror"`
+
+	// MinFreeSpaceGB skips a sync cycle when a target Harbor project has less
+	// storage quota left, and skips pulling an image when the Docker data
+	// root has less disk space free.
+	MinFreeSpaceGB float64 `json:"min_free_space_gb" jsonschema:"minimum=0"`
+
+	// MaxPullSize skips images whose compressed layers add up to more bytes.
+	MaxPullSize int64 `json:"max_pull_size" jsonschema:"minimum=0"`
+
+	// WebhookListenAddr starts an HTTP server receiving registry push
+	// notifications that sync matching images right away, e.g. ":8080".
+	WebhookListenAddr string `json:"webhook_listen_addr"`
+
+	// WebhookToken is the bearer token registry notifications must send in
+	// their Authorization header, required with WebhookListenAddr. It may
+	// reference environment variables, e.g. "${WEBHOOK_TOKEN}".
+	WebhookToken string `json:"webhook_token"`
+
+	// AdminListenAddr starts an HTTP server streaming the sync logs over a
+	// WebSocket at /ws/logs, e.g. ":8081".
+	AdminListenAddr string `json:"admin_listen_addr"`
+}
+
+// concurrency returns how many images are synced in parallel, at least one.
+func (c *Config) concurrency() int {
+	if c.Concurrency < 1 {
+		return 1
+	}
+	return c.Concurrency
+}
+
+// userAgent returns the User-Agent sent with Docker API and registry requests.
+// The daemon forwards it upstream when it talks to registries.
+func (c *Config) userAgent() string {
+	if c.UserAgent != "" {
+		return c.UserAgent
+	}
+	return "registry-sync/" + BuildVersion
+}
+
+// parseDuration parses an optional Go duration from the config, logging and
+// ignoring invalid values.
+func parseDuration(logger *slog.Logger, field, value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Warn("Invalid duration", "field", field, "value", value, "error", err)
+		return 0
+	}
+	return d
+}
+
+func filterImagesByGroup(images []ImageConfig, group string) []ImageConfig {
+	var filtered []ImageConfig
+	for _, img := range images {
+		if img.GroupBy == group {
+			filtered = append(filtered, img)
+		}
+	}
+	return filtered
+}
+
+// lookupAuth returns the encoded auth of the registry the image reference
+// belongs to. The longest matching prefix wins, so "docker.io/library" takes
+// precedence over "docker.io" regardless of map iteration order.
+func lookupAuth(auths map[string]RegistryAuth, ref string) string {
+	return lookupRegistryAuth(auths, ref).Auth
+}
+
+// lookupRegistryAuth returns the auths entry matching ref, or a zero value.
+func lookupRegistryAuth(auths map[string]RegistryAuth, ref string) RegistryAuth {
+	var auth RegistryAuth
+	var best string
+	for registry, a := range auths {
+		if strings.HasPrefix(ref, registry) && len(registry) > len(best) {
+			auth, best = a, registry
+		}
+	}
+	return auth
+}
+
+// envAuth encodes the base64 "user:password" value of the environment
+// variable name as a Docker registry auth.
+func envAuth(name string) (string, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return "", fmt.Errorf("auth environment variable %s is not set", name)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", fmt.Errorf("decode auth environment variable %s failed: %w", name, err)
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", fmt.Errorf("auth environment variable %s is not user:password", name)
+	}
+	return registry.EncodeAuthConfig(registry.AuthConfig{Username: username, Password: password})
+}
+
+// loadOptions controls how a config is fetched from its source.
+type loadOptions struct {
+	// Accept is sent as the Accept header when fetching a remote config.
+	Accept string
+	// DecryptKey is the age identity or GPG keyring file used for encrypted configs.
+	DecryptKey string
+	// S3Endpoint, S3Region and S3ForcePathStyle configure s3:// sources,
+	// e.g. for MinIO or Ceph.
+	S3Endpoint       string
+	S3Region         string
+	S3ForcePathStyle bool
+	// HTTPTimeout and HTTPMaxRedirects limit fetching an HTTP config. A zero
+	// timeout waits forever.
+	HTTPTimeout      time.Duration
+	HTTPMaxRedirects int
+	// Format is "json" or "toml", detected from the file extension when empty.
+	Format string
+	// GZipConfig decompresses gzip compressed configs, such as *.json.gz
+	// files or bodies served with Content-Encoding: gzip.
+	GZipConfig bool
+	// Logger receives messages about the loaded config.
+	Logger *slog.Logger
+
+	// version is the ETag or Last-Modified header of the last fetched remote config.
+	version string
+}
+
+// httpClient returns the client fetching HTTP configs.
+func (o *loadOptions) httpClient() *http.Client {
+	if o == nil {
+		return http.DefaultClient
+	}
+	return &http.Client{
+		Timeout: o.HTTPTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= o.HTTPMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", len(via))
+			}
+			return nil
+		},
+	}
+}
+
+func (o *loadOptions) logger() *slog.Logger {
+	if o == nil || o.Logger == nil {
+		return slog.Default()
+	}
+	return o.Logger
 }
 
-func loadConfig(path string) (*Config, error) {
+func fetchHTTPConfig(path string, opts *loadOptions) ([]byte, http.Header, error) {
+	req, err := newConfigRequest(http.MethodGet, path, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := opts.httpClient().Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch config: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return body, resp.Header, err
+}
+
+// newConfigRequest builds a request for a remote config, moving credentials
+// in the URL to a Basic authorization header.
+func newConfigRequest(method, path string, opts *loadOptions) (*http.Request, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config url: %w", err)
+	}
+	var user *url.Userinfo
+	user, u.User = u.User, nil
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config url: %w", err)
+	}
+	if user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+	}
+	if opts != nil && opts.Accept != "" {
+		req.Header.Set("Accept", opts.Accept)
+	}
+	return req, nil
+}
+
+// readConfig fetches and decrypts the config at path and returns it as JSON.
+func readConfig(path string, opts *loadOptions) ([]byte, error) {
 	var body []byte
 	var err error
+	encryption := configEncryption(path)
 
 	if strings.HasPrefix(path, "http") {
-		resp, httpErr := http.Get(path)
-		if httpErr != nil {
-			return nil, fmt.Errorf("failed to fetch config: %w", httpErr)
+		var header http.Header
+		body, header, err = fetchHTTPConfig(path, opts)
+		if v := header.Get("X-Decrypt"); v != "" {
+			encryption = strings.ToLower(v)
+		}
+		if opts != nil {
+			opts.version = configVersion(header)
 		}
-		defer resp.Body.Close()
-		body, err = io.ReadAll(resp.Body)
+	} else if isAWSConfigSource(path) {
+		body, err = fetchAWSConfig(context.Background(), path)
+	} else if strings.HasPrefix(path, schemeGCS) {
+		body, err = fetchGCSConfig(context.Background(), path)
+	} else if strings.HasPrefix(path, schemeS3) {
+		body, err = fetchS3Config(context.Background(), path, opts)
 	} else {
 		body, err = os.ReadFile(path)
 	}
@@ -59,18 +508,81 @@ func loadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config: %w", err)
 	}
 
+	if encryption != "" {
+		keyFile := ""
+		if opts != nil {
+			keyFile = opts.DecryptKey
+		}
+		if body, err = decryptConfig(body, encryption, keyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if body, err = gunzipConfig(body, opts); err != nil {
+		return nil, err
+	}
+
+	if configFormat(path, opts) == formatTOML {
+		return tomlToJSON(body)
+	}
+	return body, nil
+}
+
+// gunzipConfig decompresses body when GZipConfig is set and it starts with
+// the gzip magic number. Checking the body rather than the extension or
+// Content-Encoding also covers bodies the HTTP client already decompressed.
+func gunzipConfig(body []byte, opts *loadOptions) ([]byte, error) {
+	if opts == nil || !opts.GZipConfig || !bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		return body, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress config: %w", err)
+	}
+	defer r.Close()
+	if body, err = io.ReadAll(r); err != nil {
+		return nil, fmt.Errorf("failed to decompress config: %w", err)
+	}
+	return body, nil
+}
+
+func loadConfig(path string, opts *loadOptions) (*Config, error) {
+	body, err := readExtendedConfig(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := opts.logger()
 	config := &Config{}
 	if e := json.Unmarshal(body, config); e != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", e)
 	}
+	if etcd := config.Etcd; etcd.enabled() {
+		if body, err = fetchEtcdConfig(etcd); err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		if body, err = gunzipConfig(body, opts); err != nil {
+			return nil, err
+		}
+		config = &Config{}
+		if e := json.Unmarshal(body, config); e != nil {
+			return nil, fmt.Errorf("failed to parse config: %w", e)
+		}
+		config.Etcd = etcd
+	}
 
 	if config.Auths == nil || len(config.Auths) == 0 {
-		log.Printf("No auths found in config, loading default auth")
-		config.Auths = loadDefaultAuth()
+		logger.Info("No auths found in config, loading default auth")
+		config.Auths = loadDefaultAuth(logger)
 	} else {
-		log.Printf("Found auths in config: %+v", config.Auths)
+		logger.Info("Found auths in config", "registries", len(config.Auths))
 		auths := make(map[string]RegistryAuth)
 		for i, auth := range config.Auths {
+			// Credentials may reference environment variables, e.g. "${REGISTRY_PASSWORD}",
+			// so rotated secrets are picked up whenever the config is reloaded.
+			auth.Auth = os.ExpandEnv(auth.Auth)
+			auth.Username = os.ExpandEnv(auth.Username)
+			auth.Password = os.ExpandEnv(auth.Password)
 			if auth.Auth == "" {
 				authConfig := registry.AuthConfig{
 					Username: auth.Username,
@@ -78,13 +590,15 @@ func loadConfig(path string) (*Config, error) {
 				}
 				authStr, e := registry.EncodeAuthConfig(authConfig)
 				if e != nil {
-					log.Printf("Failed to encode auth for %s: %v", auth.Username, e)
+					logger.Error("Failed to encode auth", "username", auth.Username, "error", e)
 					continue
 				}
 				auths[i] = RegistryAuth{
-					Auth: authStr,
+					Auth:          authStr,
+					PushRateLimit: auth.PushRateLimit,
+					Insecure:      auth.Insecure,
 				}
-				log.Printf("Encoded auth for %s", auth.Auth)
+				logger.Debug("Encoded auth", "registry", i)
 			} else {
 				auths[i] = auth
 			}
@@ -95,7 +609,7 @@ func loadConfig(path string) (*Config, error) {
 	return config, nil
 }
 
-func loadDefaultAuth() map[string]RegistryAuth {
+func loadDefaultAuth(logger *slog.Logger) map[string]RegistryAuth {
 
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -103,36 +617,66 @@ func loadDefaultAuth() map[string]RegistryAuth {
 	}
 
 	conf := path.Join(home, ".docker", "config.json")
-	log.Printf("Looking for auth in %s", conf)
+	logger.Info("Looking for auth", "path", conf)
 	if _, e := os.Stat(conf); e != nil {
-		log.Printf("No auth found in %s", conf)
+		logger.Info("No auth found", "path", conf)
 		return nil
 	}
 
 	data, err := os.ReadFile(conf)
 	if err != nil {
-		log.Printf("Failed to read %s: %v", conf, err)
+		logger.Error("Failed to read docker config", "path", conf, "error", err)
 		return nil
 	}
 
 	var dockerConfig DockerConfig
 	if e := json.Unmarshal(data, &dockerConfig); e != nil {
-		log.Printf("Failed to parse %s: %v", conf, e)
+		logger.Error("Failed to parse docker config", "path", conf, "error", e)
 		return nil
 	}
+	if dockerConfig.Auths == nil {
+		dockerConfig.Auths = make(map[string]DockerAuth)
+	}
+	for i := range dockerConfig.CredHelpers {
+		if _, ok := dockerConfig.Auths[i]; !ok {
+			dockerConfig.Auths[i] = DockerAuth{}
+		}
+	}
 	auths := make(map[string]RegistryAuth)
 	for i, auth := range dockerConfig.Auths {
-		decodedAuth, e := base64.URLEncoding.DecodeString(auth.Auth)
-		if e != nil {
-			continue
-		}
-		credentials := strings.SplitN(string(decodedAuth), ":", 2)
-		if len(credentials) != 2 {
-			continue
+		var authConfig registry.AuthConfig
+		helper := dockerConfig.CredHelpers[i]
+		if helper == "" {
+			helper = dockerConfig.CredsStore
 		}
-		authConfig := registry.AuthConfig{
-			Username: credentials[0],
-			Password: credentials[1],
+		if auth.CredentialProcess != "" {
+			username, secret, e := runCredentialProcess(auth.CredentialProcess)
+			if e != nil {
+				logger.Error("Failed to run credential process", "registry", i, "error", e)
+				continue
+			}
+			authConfig.Username, authConfig.Password = username, secret
+		} else if auth.Auth == "" && helper != "" {
+			username, secret, e := getHelperCredentials(helper, i)
+			if e != nil {
+				logger.Error("Failed to get credentials from helper", "registry", i, "helper", helper, "error", e)
+				continue
+			}
+			if username == identityTokenUsername {
+				authConfig.IdentityToken = secret
+			} else {
+				authConfig.Username, authConfig.Password = username, secret
+			}
+		} else {
+			decodedAuth, e := base64.URLEncoding.DecodeString(auth.Auth)
+			if e != nil {
+				continue
+			}
+			credentials := strings.SplitN(string(decodedAuth), ":", 2)
+			if len(credentials) != 2 {
+				continue
+			}
+			authConfig.Username, authConfig.Password = credentials[0], credentials[1]
 		}
 		authStr, e := registry.EncodeAuthConfig(authConfig)
 		if e != nil {
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+const (
+	schemeAWSSecretsManager = "aws-sm://"
+	schemeAWSParameterStore = "aws-ssm://"
+)
+
+func isAWSConfigSource(path string) bool {
+	return strings.HasPrefix(path, schemeAWSSecretsManager) || strings.HasPrefix(path, schemeAWSParameterStore)
+}
+
+// fetchAWSConfig reads the config from AWS Secrets Manager
+// (aws-sm://region/secret-name) or SSM Parameter Store (aws-ssm://region/path).
+// KMS encrypted values are decrypted by the service.
+func fetchAWSConfig(ctx context.Context, path string) ([]byte, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config url: %w", err)
+	}
+	region, name := u.Host, strings.TrimPrefix(u.Path, "/")
+	if region == "" || name == "" {
+		return nil, fmt.Errorf("invalid config url %s, expected %sregion/name", path, u.Scheme+"://")
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws credentials: %w", err)
+	}
+
+	if strings.HasPrefix(path, schemeAWSSecretsManager) {
+		out, e := secretsmanager.NewFromConfig(cfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(name),
+		})
+		if e != nil {
+			return nil, fmt.Errorf("failed to fetch secret %s: %w", name, e)
+		}
+		if out.SecretString != nil {
+			return []byte(*out.SecretString), nil
+		}
+		return out.SecretBinary, nil
+	}
+
+	// Parameter names are absolute paths in SSM.
+	out, err := ssm.NewFromConfig(cfg).GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String("/" + name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch parameter /%s: %w", name, err)
+	}
+	return []byte(aws.ToString(out.Parameter.Value)), nil
+}
+
+const schemeS3 = "s3://"
+
+// fetchS3Config reads s3://bucket/key from AWS S3 or an S3 compatible store
+// such as MinIO or Ceph when an endpoint is configured.
+func fetchS3Config(ctx context.Context, path string, opts *loadOptions) ([]byte, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config url: %w", err)
+	}
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("invalid config url %s, expected %sbucket/key", path, schemeS3)
+	}
+	if opts == nil {
+		opts = &loadOptions{}
+	}
+	var cfgOpts []func(*awsconfig.LoadOptions) error
+	if opts.S3Region != "" {
+		cfgOpts = append(cfgOpts, awsconfig.WithRegion(opts.S3Region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, cfgOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws credentials: %w", err)
+	}
+	cli := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if opts.S3Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.S3Endpoint)
+		}
+		o.UsePathStyle = opts.S3ForcePathStyle
+	})
+	out, err := cli.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", path, err)
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
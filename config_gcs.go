@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+const schemeGCS = "gs://"
+
+// fetchGCSConfig reads gs://bucket/path/config.json using Application
+// Default Credentials.
+func fetchGCSConfig(ctx context.Context, path string) ([]byte, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config url: %w", err)
+	}
+	bucket, object := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || object == "" {
+		return nil, fmt.Errorf("invalid config url %s, expected %sbucket/object", path, schemeGCS)
+	}
+	cli, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gcs client: %w", err)
+	}
+	defer cli.Close()
+	reader, err := cli.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
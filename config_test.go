@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestLookupAuthOverlappingPrefixes(t *testing.T) {
+	auths := map[string]RegistryAuth{
+		"docker.io":         {Auth: "hub"},
+		"docker.io/library": {Auth: "library"},
+	}
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"docker.io/library/nginx", "library"},
+		{"docker.io/library/nginx:1.27", "library"},
+		{"docker.io/other/x", "hub"},
+		{"quay.io/other/x", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			if got := lookupAuth(auths, tt.ref); got != tt.want {
+				t.Errorf("lookupAuth(%q) = %q, want %q", tt.ref, got, tt.want)
+			}
+			if got := lookupRegistryAuth(auths, tt.ref).Auth; got != tt.want {
+				t.Errorf("lookupRegistryAuth(%q).Auth = %q, want %q", tt.ref, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/invopop/jsonschema"
+)
+
+// writeConfigSchema writes the JSON schema of the config file to w.
+func writeConfigSchema(w io.Writer) error {
+	r := &jsonschema.Reflector{
+		// Every field is optional.
+		RequiredFromJSONSchemaTags: true,
+	}
+	schema := r.Reflect(&Config{})
+	schema.Title = "registry-sync config"
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(schema)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/images/converter"
+	"github.com/containerd/containerd/pkg/snapshotters"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
+)
+
+const (
+	runtimeContainerd = "containerd"
+	pullModeLazy      = "lazy"
+
+	defaultContainerdAddress   = "/run/containerd/containerd.sock"
+	defaultContainerdNamespace = "k8s.io"
+	defaultLazySnapshotter     = "stargz"
+)
+
+func newContainerdClient(config *Config) (*containerd.Client, error) {
+	address, namespace := config.ContainerdAddress, config.ContainerdNamespace
+	if address == "" {
+		address = defaultContainerdAddress
+	}
+	if namespace == "" {
+		namespace = defaultContainerdNamespace
+	}
+	cli, err := containerd.New(address, containerd.WithDefaultNamespace(namespace))
+	if err != nil {
+		return nil, fmt.Errorf("connect to containerd at %s failed: %w", address, err)
+	}
+	return cli, nil
+}
+
+// containerdResolver resolves registries with the credentials and insecure
+// settings of the config.
+func (s *syncer) containerdResolver() remotes.Resolver {
+	credentials := func(host string) (string, string, error) {
+		domain := host
+		if domain == "registry-1.docker.io" {
+			domain = "docker.io"
+		}
+		auth := s.registry.credentials(&imageRef{Raw: domain, Domain: domain})
+		if auth == nil {
+			return "", "", nil
+		}
+		if auth.IdentityToken != "" {
+			return "", auth.IdentityToken, nil
+		}
+		return auth.Username, auth.Password, nil
+	}
+	plainHTTP := func(host string) (bool, error) {
+		return lookupRegistryAuth(s.config.Auths, host).Insecure, nil
+	}
+	return docker.NewResolver(docker.ResolverOptions{
+		Hosts: docker.ConfigureDefaultRegistries(
+			docker.WithAuthorizer(docker.NewDockerAuthorizer(docker.WithAuthCreds(credentials))),
+			docker.WithPlainHTTP(plainHTTP),
+		),
+	})
+}
+
+// containerdSync pulls the source image of all platforms into the containerd
+// image store, unpacking the one of this node so the kubelet can use it, and
+// pushes it to the target. With NormalizeManifest the target is converted to
+// OCI manifests in the content store first.
+func (s *syncer) containerdSync(ctx context.Context, img *ImageConfig, result *imageResult) error {
+	src, err := parseImageRef(img.Source)
+	if err != nil {
+		return err
+	}
+	if img.PullMode == pullModeLazy {
+		return s.containerdLazySync(ctx, img, src, result)
+	}
+	dst, err := parseImageRef(img.Target)
+	if err != nil {
+		return err
+	}
+	resolver := s.containerdResolver()
+
+	start := time.Now()
+	s.progress.setStatus(img.Source, progressPulling)
+	s.events.Publish(Event{Type: ImagePullStarted, Image: img.Source, Start: start, Result: result})
+	pulled, err := s.containerd.Pull(ctx, src.String(), containerd.WithResolver(resolver), containerd.WithPlatformMatcher(platforms.All))
+	if err == nil {
+		err = pulled.Unpack(ctx, "")
+	}
+	if err != nil {
+		err = &PullError{Image: img.Source, Step: stepPull, Cause: err}
+	}
+	s.events.Publish(Event{Type: ImagePullCompleted, Image: img.Source, Start: start, Err: err, Result: result})
+	if err != nil {
+		return err
+	}
+	result.PullDuration = time.Since(start)
+	result.Digest = pulled.Target().Digest.String()
+	if size, e := pulled.Size(ctx); e == nil {
+		result.Bytes = size
+	}
+	s.logger.Info("pull image success", "image", img.Source)
+
+	start = time.Now()
+	s.progress.setStatus(img.Source, progressTagging)
+	target := images.Image{Name: dst.String(), Target: pulled.Target()}
+	store := s.containerd.ImageService()
+	if img.NormalizeManifest {
+		// The target gets OCI manifests converted in the content store.
+		var converted *images.Image
+		if converted, err = converter.Convert(ctx, s.containerd, dst.String(), pulled.Name(), converter.WithDockerToOCI(true)); err == nil {
+			target = *converted
+		}
+	} else if _, e := store.Create(ctx, target); errdefs.IsAlreadyExists(e) {
+		_, err = store.Update(ctx, target)
+	} else {
+		err = e
+	}
+	if err != nil {
+		err = &TagError{Image: img.Source, Target: img.Target, Step: stepTag, Cause: err}
+		s.audit.record("tag", img.Target, start, err)
+		return err
+	}
+	s.audit.record("tag", img.Target, start, nil)
+
+	start = time.Now()
+	s.progress.setStatus(img.Source, progressPushing)
+	if err = s.limiter.wait(ctx, img.Target); err == nil {
+		err = s.containerd.Push(ctx, dst.String(), target.Target, containerd.WithResolver(resolver), containerd.WithPlatformMatcher(platforms.All))
+	}
+	if err != nil {
+		err = &PushError{Image: img.Target, Step: stepPush, Cause: err}
+	}
+	s.events.Publish(Event{Type: ImagePushCompleted, Image: img.Target, Start: start, Err: err, Result: result})
+	if err != nil {
+		return err
+	}
+	result.PushDuration = time.Since(start)
+	s.logger.Info("push image success", "image", img.Target)
+
+	if s.config.CleanupAfterSync {
+		for _, name := range []string{dst.String(), src.String()} {
+			if e := store.Delete(ctx, name); e != nil {
+				s.logger.Error("Failed to remove local image", "image", name, "error", e)
+			}
+		}
+	}
+	return nil
+}
+
+// containerdLazySync registers the source image with a remote snapshotter,
+// which fetches layer contents when a container reads them instead of
+// downloading them up front. The content store then lacks the layers, so the
+// image is copied to the target between the registries.
+func (s *syncer) containerdLazySync(ctx context.Context, img *ImageConfig, src *imageRef, result *imageResult) error {
+	snapshotter := s.config.LazySnapshotter
+	if snapshotter == "" {
+		snapshotter = defaultLazySnapshotter
+	}
+
+	start := time.Now()
+	s.progress.setStatus(img.Source, progressPulling)
+	s.events.Publish(Event{Type: ImagePullStarted, Image: img.Source, Start: start, Result: result})
+	pulled, err := s.containerd.Pull(ctx, src.String(),
+		containerd.WithResolver(s.containerdResolver()),
+		containerd.WithPullUnpack,
+		containerd.WithPullSnapshotter(snapshotter),
+		containerd.WithImageHandlerWrapper(snapshotters.AppendInfoHandlerWrapper(src.String())),
+	)
+	if err != nil {
+		err = &PullError{Image: img.Source, Step: stepPull, Cause: err}
+	}
+	s.events.Publish(Event{Type: ImagePullCompleted, Image: img.Source, Start: start, Err: err, Result: result})
+	if err != nil {
+		return err
+	}
+	result.PullDuration = time.Since(start)
+	result.Digest = pulled.Target().Digest.String()
+	s.logger.Info("lazy pull image success", "image", img.Source, "snapshotter", snapshotter)
+
+	start = time.Now()
+	s.progress.setStatus(img.Source, progressPushing)
+	var copied int64
+	if err = s.limiter.wait(ctx, img.Target); err == nil {
+		copied, _, err = s.deltaSync(ctx, img)
+	}
+	if err != nil {
+		err = &PushError{Image: img.Target, Step: stepPush, Cause: fmt.Errorf("copy from %s: %w", img.Source, err)}
+	}
+	s.events.Publish(Event{Type: ImagePushCompleted, Image: img.Target, Start: start, Err: err, Result: result})
+	if err != nil {
+		return err
+	}
+	result.PushDuration = time.Since(start)
+	result.Bytes = copied
+	s.logger.Info("push image success", "image", img.Target)
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// runCredentialProcess runs command through the shell and parses the
+// credentials it prints to stdout, as done by AWS CLI v2 SSO helpers.
+func runCredentialProcess(command string) (string, string, error) {
+	cmd := exec.Command("sh", "-c", command)
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", "", fmt.Errorf("credential process %q failed: %w: %s", command, err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", "", fmt.Errorf("credential process %q failed: %w", command, err)
+	}
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if e := json.Unmarshal(output, &creds); e != nil {
+		return "", "", fmt.Errorf("decode output of credential process %q failed: %w", command, e)
+	}
+	if creds.Username == "" || creds.Secret == "" {
+		return "", "", fmt.Errorf("credential process %q returned no username or secret", command)
+	}
+	return creds.Username, creds.Secret, nil
+}
+
+// identityTokenUsername is the username credential helpers return for
+// identity tokens, whose secret is a refresh token rather than a password.
+const identityTokenUsername = "<token>"
+
+// getHelperCredentials asks the docker-credential-<helper> program, such as
+// docker-credential-osxkeychain, for the credentials of serverURL using the
+// Docker credential helper protocol.
+func getHelperCredentials(helper, serverURL string) (string, string, error) {
+	program := "docker-credential-" + helper
+	cmd := exec.Command(program, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", "", fmt.Errorf("%s get %s failed: %s", program, serverURL, strings.TrimSpace(string(output)))
+		}
+		return "", "", fmt.Errorf("%s get %s failed: %w", program, serverURL, err)
+	}
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if e := json.Unmarshal(output, &creds); e != nil {
+		return "", "", fmt.Errorf("decode output of %s failed: %w", program, e)
+	}
+	return creds.Username, creds.Secret, nil
+}
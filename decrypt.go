@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	agearmor "filippo.io/age/armor"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+const (
+	encryptionAge = "age"
+	encryptionGPG = "gpg"
+)
+
+// configEncryption infers the encryption of a config file from its extension.
+func configEncryption(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".age"):
+		return encryptionAge
+	case strings.HasSuffix(path, ".gpg"):
+		return encryptionGPG
+	default:
+		return ""
+	}
+}
+
+// decryptConfig decrypts an age or GPG encrypted config body with the
+// identities or private keyring in keyFile.
+func decryptConfig(body []byte, encryption, keyFile string) ([]byte, error) {
+	if keyFile == "" {
+		return nil, fmt.Errorf("config is %s encrypted but no decrypt key is set", encryption)
+	}
+	key, err := os.Open(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open decrypt key: %w", err)
+	}
+	defer key.Close()
+
+	switch encryption {
+	case encryptionAge:
+		identities, e := age.ParseIdentities(key)
+		if e != nil {
+			return nil, fmt.Errorf("failed to parse age identities: %w", e)
+		}
+		reader, e := age.Decrypt(armoredOrBinary(body), identities...)
+		if e != nil {
+			return nil, fmt.Errorf("failed to decrypt config: %w", e)
+		}
+		return io.ReadAll(reader)
+	case encryptionGPG:
+		keyring, e := openpgp.ReadArmoredKeyRing(key)
+		if e != nil {
+			if _, se := key.Seek(0, io.SeekStart); se != nil {
+				return nil, se
+			}
+			if keyring, e = openpgp.ReadKeyRing(key); e != nil {
+				return nil, fmt.Errorf("failed to read gpg keyring: %w", e)
+			}
+		}
+		message := io.Reader(bytes.NewReader(body))
+		if block, ae := armor.Decode(bytes.NewReader(body)); ae == nil {
+			message = block.Body
+		}
+		md, e := openpgp.ReadMessage(message, keyring, nil, nil)
+		if e != nil {
+			return nil, fmt.Errorf("failed to decrypt config: %w", e)
+		}
+		return io.ReadAll(md.UnverifiedBody)
+	default:
+		return nil, fmt.Errorf("unsupported config encryption %q", encryption)
+	}
+}
+
+// armoredOrBinary accepts both ASCII armored and binary age files.
+func armoredOrBinary(body []byte) io.Reader {
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("-----BEGIN AGE ENCRYPTED FILE-----")) {
+		return agearmor.NewReader(bytes.NewReader(body))
+	}
+	return bytes.NewReader(body)
+}
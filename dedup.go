@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/docker/docker/client"
+)
+
+// digestCache maps the manifest digests pulled in a cycle to the local
+// reference they were pulled as, so tags sharing a digest are pulled once.
+type digestCache struct {
+	mu   sync.Mutex
+	refs map[string]string
+}
+
+func newDigestCache() *digestCache {
+	return &digestCache{refs: make(map[string]string)}
+}
+
+func (c *digestCache) get(digest string) (string, bool) {
+	if digest == "" {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ref, ok := c.refs[digest]
+	return ref, ok
+}
+
+func (c *digestCache) put(digest, ref string) {
+	if digest == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.refs[digest]; !ok {
+		c.refs[digest] = ref
+	}
+}
+
+// sourceDigest returns the manifest digest the source reference resolves to,
+// or an empty string when it cannot be resolved.
+func (s *syncer) sourceDigest(ctx context.Context, source string) string {
+	ref, err := parseImageRef(source)
+	if err != nil {
+		return ""
+	}
+	info, ok, err := s.registry.headManifest(ctx, ref)
+	if err != nil || !ok {
+		return ""
+	}
+	return info.Digest
+}
+
+// retagPulled tags the image already pulled with the same digest as source
+// and reports whether that made pulling source unnecessary.
+func (s *syncer) retagPulled(ctx context.Context, cli *client.Client, source, digest string) bool {
+	local, ok := s.digests.get(digest)
+	if !ok || local == source {
+		return false
+	}
+	return cli.ImageTag(ctx, local, source) == nil
+}
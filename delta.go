@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type LayerDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+	Digest    string `json:"digest"`
+}
+
+type ManifestV2 struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        LayerDescriptor   `json:"config"`
+	Layers        []LayerDescriptor `json:"layers"`
+}
+
+func isIndexMediaType(mediaType string) bool {
+	return mediaType == ocispec.MediaTypeImageIndex || mediaType == mediaTypeDockerManifestList
+}
+
+// computeLayerDiff returns the layers of source that target does not have.
+func computeLayerDiff(source, target ManifestV2) []LayerDescriptor {
+	existing := make(map[string]bool, len(target.Layers))
+	for _, layer := range target.Layers {
+		existing[layer.Digest] = true
+	}
+	var missing []LayerDescriptor
+	for _, layer := range source.Layers {
+		if !existing[layer.Digest] {
+			missing = append(missing, layer)
+		}
+	}
+	return missing
+}
+
+// targetLayers collects the layers of the image currently at the target
+// reference, flattening manifest lists. A missing target yields no layers.
+func (s *syncer) targetLayers(ctx context.Context, dst *imageRef) (ManifestV2, error) {
+	if _, ok, err := s.registry.headManifest(ctx, dst); err != nil || !ok {
+		return ManifestV2{}, err
+	}
+	return s.manifestLayers(ctx, dst)
+}
+
+// manifestLayers returns the manifest at ref with the layers of every
+// platform when it is a manifest list.
+func (s *syncer) manifestLayers(ctx context.Context, ref *imageRef) (ManifestV2, error) {
+	var all ManifestV2
+	info, err := s.registry.getManifest(ctx, ref)
+	if err != nil {
+		return all, err
+	}
+	if !isIndexMediaType(info.MediaType) {
+		err = json.Unmarshal(info.Body, &all)
+		return all, err
+	}
+	var index ocispec.Index
+	if e := json.Unmarshal(info.Body, &index); e != nil {
+		return all, e
+	}
+	for _, m := range index.Manifests {
+		child, e := s.registry.getManifest(ctx, ref.withReference(m.Digest.String()))
+		if e != nil {
+			return all, e
+		}
+		var manifest ManifestV2
+		if e = json.Unmarshal(child.Body, &manifest); e != nil {
+			return all, e
+		}
+		all.Layers = append(all.Layers, manifest.Layers...)
+	}
+	return all, nil
+}
+
+// checkRegistryCopies rejects the options that need the image in the local
+// daemon on images copied through the registry API by delta_sync or
+// normalize_manifest.
+func checkRegistryCopies(images []ImageConfig, filters imageFilters) error {
+	for _, img := range images {
+		if !img.DeltaSync && !img.NormalizeManifest {
+			continue
+		}
+		if img.Transform != "" {
+			return fmt.Errorf("image %s: transform cannot be combined with delta_sync or normalize_manifest", img.Source)
+		}
+		if len(filters) > 0 {
+			return fmt.Errorf("image %s: -image-filter cannot be combined with delta_sync or normalize_manifest", img.Source)
+		}
+	}
+	return nil
+}
+
+// deltaSync copies an image directly between registries, transferring only
+// the layers the target does not already hold. With NormalizeManifest set the
+// manifests are converted to OCI on the way. It returns the bytes copied and
+// the digest of the target manifest.
+func (s *syncer) deltaSync(ctx context.Context, img *ImageConfig) (int64, string, error) {
+	src, err := parseImageRef(img.Source)
+	if err != nil {
+		return 0, "", err
+	}
+	dst, err := parseImageRef(img.Target)
+	if err != nil {
+		return 0, "", err
+	}
+	previous, err := s.targetLayers(ctx, dst)
+	if err != nil {
+		return 0, "", fmt.Errorf("inspect target %s failed: %w", dst, err)
+	}
+	info, err := s.registry.getManifest(ctx, src)
+	if err != nil {
+		return 0, "", err
+	}
+	if !isIndexMediaType(info.MediaType) {
+		copied, e := s.copyManifestDelta(ctx, src, dst, info, previous)
+		if e != nil {
+			return copied, "", e
+		}
+		mediaType, body := info.MediaType, info.Body
+		if img.NormalizeManifest {
+			var desc ocispec.Descriptor
+			if desc, body, e = normalizeManifest(body, nil); e != nil {
+				return copied, "", fmt.Errorf("normalize manifest %s failed: %w", src, e)
+			}
+			mediaType = desc.MediaType
+		}
+		digest, e := s.registry.putManifest(ctx, dst, mediaType, body)
+		return copied, digest, e
+	}
+
+	var index ocispec.Index
+	if e := json.Unmarshal(info.Body, &index); e != nil {
+		return 0, "", fmt.Errorf("decode manifest list %s failed: %w", src, e)
+	}
+	var total int64
+	children := make(map[string]ocispec.Descriptor)
+	for _, m := range index.Manifests {
+		digest := m.Digest.String()
+		child, e := s.registry.getManifest(ctx, src.withReference(digest))
+		if e != nil {
+			return total, "", e
+		}
+		copied, e := s.copyManifestDelta(ctx, src, dst, child, previous)
+		total += copied
+		if e != nil {
+			return total, "", e
+		}
+		mediaType, body, ref := child.MediaType, child.Body, dst.withReference(digest)
+		if img.NormalizeManifest {
+			var desc ocispec.Descriptor
+			if desc, body, e = normalizeManifest(body, nil); e != nil {
+				return total, "", fmt.Errorf("normalize manifest %s failed: %w", src.withReference(digest), e)
+			}
+			children[digest] = desc
+			mediaType, ref = desc.MediaType, dst.withReference(desc.Digest.String())
+		}
+		if _, e = s.registry.putManifest(ctx, ref, mediaType, body); e != nil {
+			return total, "", e
+		}
+	}
+	mediaType, body := info.MediaType, info.Body
+	if img.NormalizeManifest {
+		var desc ocispec.Descriptor
+		if desc, body, err = normalizeManifest(body, children); err != nil {
+			return total, "", fmt.Errorf("normalize manifest list %s failed: %w", src, err)
+		}
+		mediaType = desc.MediaType
+	}
+	digest, err := s.registry.putManifest(ctx, dst, mediaType, body)
+	return total, digest, err
+}
+
+func (s *syncer) copyManifestDelta(ctx context.Context, src, dst *imageRef, info *manifestInfo, previous ManifestV2) (int64, error) {
+	var manifest ManifestV2
+	if e := json.Unmarshal(info.Body, &manifest); e != nil {
+		return 0, fmt.Errorf("decode manifest %s failed: %w", src, e)
+	}
+	missing := computeLayerDiff(manifest, previous)
+	s.logger.Info("delta sync layers missing in target", "image", src.String(), "missing", len(missing), "layers", len(manifest.Layers))
+
+	var copied int64
+	blobs := append([]LayerDescriptor{manifest.Config}, missing...)
+	for _, blob := range blobs {
+		if e := s.registry.copyBlob(ctx, src, dst, blob.Digest, blob.Size); e != nil {
+			return copied, e
+		}
+		copied += blob.Size
+	}
+	return copied, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	diffSame          = "="
+	diffSourceNewer   = ">"
+	diffTargetNewer   = "<"
+	diffTargetMissing = "!"
+)
+
+type imageDiff struct {
+	Source       string
+	Target       string
+	Status       string
+	SourceDigest string
+	TargetDigest string
+}
+
+// diffImage compares the source and target manifests of an image without
+// pulling it. When the digests differ, the image creation times decide which
+// side is newer.
+func (s *syncer) diffImage(ctx context.Context, img *ImageConfig) (*imageDiff, error) {
+	src, err := parseImageRef(img.Source)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseImageRef(img.Target)
+	if err != nil {
+		return nil, err
+	}
+	d := &imageDiff{Source: img.Source, Target: img.Target}
+	srcInfo, ok, err := s.registry.headManifest(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("source %s not found", img.Source)
+	}
+	d.SourceDigest = srcInfo.Digest
+	dstInfo, ok, err := s.registry.headManifest(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		d.Status = diffTargetMissing
+		return d, nil
+	}
+	d.TargetDigest = dstInfo.Digest
+	d.SourceDigest = s.comparableDigest(ctx, src, srcInfo, dstInfo)
+	if d.SourceDigest == d.TargetDigest {
+		d.Status = diffSame
+		return d, nil
+	}
+	d.Status = diffSourceNewer
+	srcCreated, e1 := s.imageCreated(ctx, src)
+	dstCreated, e2 := s.imageCreated(ctx, dst)
+	if e1 == nil && e2 == nil && dstCreated.After(srcCreated) {
+		d.Status = diffTargetNewer
+	}
+	return d, nil
+}
+
+// comparableDigest returns the source digest to compare with the target. A
+// daemon push of a multi-arch source leaves a single platform manifest at the
+// target, so the entry of the source index with the target digest stands in
+// for the index when there is one.
+func (s *syncer) comparableDigest(ctx context.Context, src *imageRef, srcInfo, dstInfo *manifestInfo) string {
+	if !isIndexMediaType(srcInfo.MediaType) || isIndexMediaType(dstInfo.MediaType) {
+		return srcInfo.Digest
+	}
+	info, err := s.registry.getManifest(ctx, src)
+	if err != nil {
+		return srcInfo.Digest
+	}
+	var index ocispec.Index
+	if e := json.Unmarshal(info.Body, &index); e != nil {
+		return srcInfo.Digest
+	}
+	for _, m := range index.Manifests {
+		if m.Digest.String() == dstInfo.Digest {
+			return dstInfo.Digest
+		}
+	}
+	return srcInfo.Digest
+}
+
+// resolvedImages expands globs and namespace mappings the same way a sync
+// cycle does, without touching the Docker daemon.
+func (s *syncer) resolvedImages(ctx context.Context) []ImageConfig {
+	var images []ImageConfig
+	for _, img := range s.expandImages(ctx, s.config.Images) {
+		if _, archive := archivePath(img.Target); archive {
+			images = append(images, img)
+			continue
+		}
+		target, err := resolveTarget(img.Source, img.Target, s.config.NamespaceMappings)
+		if err != nil {
+			s.logger.Error("Error processing image", "image", img.Source, "error", err)
+			continue
+		}
+		img.Target = affixTag(target, img.TargetTagPrefix, img.TargetTagSuffix)
+		images = append(images, img)
+	}
+	return images
+}
+
+// printDiff writes one line per image and reports whether all images are in sync.
+func (s *syncer) printDiff(ctx context.Context, w io.Writer) bool {
+	inSync := true
+	for _, img := range s.resolvedImages(ctx) {
+		d, err := s.diffImage(ctx, &img)
+		if err != nil {
+			inSync = false
+			fmt.Fprintf(w, "? %s -> %s: %v\n", img.Source, img.Target, err)
+			continue
+		}
+		if d.Status != diffSame {
+			inSync = false
+		}
+		fmt.Fprintf(w, "%s %s -> %s", d.Status, d.Source, d.Target)
+		switch d.Status {
+		case diffSame:
+			fmt.Fprintf(w, " (%s)\n", d.SourceDigest)
+		case diffTargetMissing:
+			fmt.Fprintf(w, " (source %s, target missing)\n", d.SourceDigest)
+		default:
+			fmt.Fprintf(w, " (source %s, target %s)\n", d.SourceDigest, d.TargetDigest)
+		}
+	}
+	return inSync
+}
+
+// printDigestReport compares only the manifest digests of every source and
+// target pair and reports whether all targets match their source.
+func (s *syncer) printDigestReport(ctx context.Context, w io.Writer) bool {
+	var matched, missing, mismatched, failed int
+	for _, img := range s.resolvedImages(ctx) {
+		src, err := parseImageRef(img.Source)
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "error    %s -> %s: %v\n", img.Source, img.Target, err)
+			continue
+		}
+		dst, err := parseImageRef(img.Target)
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "error    %s -> %s: %v\n", img.Source, img.Target, err)
+			continue
+		}
+		srcInfo, ok, err := s.registry.headManifest(ctx, src)
+		if err == nil && !ok {
+			err = fmt.Errorf("source not found")
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "error    %s -> %s: %v\n", img.Source, img.Target, err)
+			continue
+		}
+		dstInfo, ok, err := s.registry.headManifest(ctx, dst)
+		if err == nil && ok {
+			srcInfo.Digest = s.comparableDigest(ctx, src, srcInfo, dstInfo)
+		}
+		switch {
+		case err != nil:
+			failed++
+			fmt.Fprintf(w, "error    %s -> %s: %v\n", img.Source, img.Target, err)
+		case !ok:
+			missing++
+			fmt.Fprintf(w, "missing  %s -> %s (source %s)\n", img.Source, img.Target, srcInfo.Digest)
+		case dstInfo.Digest != srcInfo.Digest:
+			mismatched++
+			fmt.Fprintf(w, "mismatch %s -> %s (source %s, target %s)\n", img.Source, img.Target, srcInfo.Digest, dstInfo.Digest)
+		default:
+			matched++
+			fmt.Fprintf(w, "match    %s -> %s (%s)\n", img.Source, img.Target, srcInfo.Digest)
+		}
+	}
+	fmt.Fprintf(w, "%d matching, %d missing, %d mismatched, %d errors\n", matched, missing, mismatched, failed)
+	return missing == 0 && mismatched == 0 && failed == 0
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// digestTag returns the tag "sha256-<hex>" for a manifest digest, the
+// content-addressable tag scheme cosign uses.
+func digestTag(d string) string {
+	return strings.Replace(d, ":", "-", 1)
+}
+
+// tagWithDigest adds a tag naming the digest of the pushed target, so the
+// pushed content stays reachable at an immutable tag when the floating tag
+// moves on.
+func (s *syncer) tagWithDigest(ctx context.Context, img *ImageConfig) error {
+	ref, err := parseImageRef(img.Target)
+	if err != nil {
+		return err
+	}
+	info, err := s.registry.getManifest(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("read manifest of %s failed: %w", img.Target, err)
+	}
+	d := info.Digest
+	if d == "" {
+		d = digest.FromBytes(info.Body).String()
+	}
+	tagged := ref.withReference(digestTag(d))
+	if _, err = s.registry.putManifest(ctx, tagged, info.MediaType, info.Body); err != nil {
+		return fmt.Errorf("tag %s failed: %w", tagged, err)
+	}
+	s.logger.Info("tag image with digest success", "image", img.Target, "tag", tagged.String())
+	return nil
+}
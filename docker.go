@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+const (
+	runtimeDocker = "docker"
+	runtimePodman = "podman"
+)
+
+func newDockerClient(config *Config, logger *slog.Logger) (*client.Client, error) {
+	opts := []client.Opt{
+		client.FromEnv,
+		client.WithAPIVersionNegotiation(),
+		client.WithUserAgent(config.userAgent()),
+	}
+	switch config.Runtime {
+	case "", runtimeDocker, runtimeContainerd:
+	case runtimePodman:
+		if os.Getenv(client.EnvOverrideHost) == "" {
+			opts = append(opts, client.WithHost(podmanHost()))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported runtime %q", config.Runtime)
+	}
+	if config.DockerContextName != "" {
+		contextOpts, err := dockerContextOpts(config.DockerContextName)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, contextOpts...)
+	}
+	opts = append(opts, withTransportTimeouts(
+		parseDuration(logger, "docker_dial_timeout", config.DockerDialTimeout),
+		parseDuration(logger, "docker_response_timeout", config.DockerResponseTimeout),
+	))
+	return client.NewClientWithOpts(opts...)
+}
+
+// podmanHost returns the Podman API socket, the rootless one of the current
+// user or the system socket for root.
+func podmanHost() string {
+	if os.Getuid() == 0 {
+		return "unix:///run/podman/podman.sock"
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return "unix://" + filepath.Join(dir, "podman", "podman.sock")
+	}
+	return fmt.Sprintf("unix:///run/user/%d/podman/podman.sock", os.Getuid())
+}
+
+// dockerContextOpts returns the options connecting to the daemon of a Docker
+// CLI context, read from ~/.docker/contexts like "docker --context" does.
+func dockerContextOpts(name string) ([]client.Opt, error) {
+	if name == "default" {
+		return nil, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	id := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))
+	contexts := filepath.Join(home, ".docker", "contexts")
+	data, err := os.ReadFile(filepath.Join(contexts, "meta", id, "meta.json"))
+	if err != nil {
+		return nil, fmt.Errorf("docker context %s not found: %w", name, err)
+	}
+	var meta struct {
+		Endpoints struct {
+			Docker struct {
+				Host          string
+				SkipTLSVerify bool
+			} `json:"docker"`
+		}
+	}
+	if e := json.Unmarshal(data, &meta); e != nil {
+		return nil, fmt.Errorf("failed to parse docker context %s: %w", name, e)
+	}
+	if meta.Endpoints.Docker.Host == "" {
+		return nil, fmt.Errorf("docker context %s has no docker endpoint", name)
+	}
+	opts := []client.Opt{client.WithHost(meta.Endpoints.Docker.Host)}
+	tlsDir := filepath.Join(contexts, "tls", id, "docker")
+	if _, e := os.Stat(filepath.Join(tlsDir, "cert.pem")); e == nil && !meta.Endpoints.Docker.SkipTLSVerify {
+		opts = append(opts, client.WithTLSClientConfig(
+			filepath.Join(tlsDir, "ca.pem"),
+			filepath.Join(tlsDir, "cert.pem"),
+			filepath.Join(tlsDir, "key.pem"),
+		))
+	}
+	return opts, nil
+}
+
+// withTransportTimeouts bounds how long connecting to the daemon and waiting
+// for its response headers may take. It wraps the dialer configured by
+// client.FromEnv so unix sockets and TCP hosts both keep working.
+func withTransportTimeouts(dial, response time.Duration) client.Opt {
+	return func(c *client.Client) error {
+		if dial <= 0 && response <= 0 {
+			return nil
+		}
+		// HTTPClient returns a shallow copy that shares the transport.
+		transport, ok := c.HTTPClient().Transport.(*http.Transport)
+		if !ok {
+			return fmt.Errorf("cannot apply timeouts to transport: %T", c.HTTPClient().Transport)
+		}
+		if dial > 0 {
+			dialContext := transport.DialContext
+			if dialContext == nil {
+				dialContext = (&net.Dialer{}).DialContext
+			}
+			transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				ctx, cancel := context.WithTimeout(ctx, dial)
+				defer cancel()
+				return dialContext(ctx, network, addr)
+			}
+		}
+		if response > 0 {
+			transport.ResponseHeaderTimeout = response
+		}
+		return nil
+	}
+}
+
+// clientPool hands out Docker clients so that concurrent image syncs each use
+// their own HTTP connection to the daemon instead of sharing one.
+type clientPool struct {
+	clients chan *client.Client
+	all     []*client.Client
+}
+
+func newClientPool(config *Config, logger *slog.Logger) (*clientPool, error) {
+	size := config.concurrency()
+	pool := &clientPool{
+		clients: make(chan *client.Client, size),
+	}
+	for i := 0; i < size; i++ {
+		cli, err := newDockerClient(config, logger)
+		if err != nil {
+			_ = pool.Close()
+			return nil, err
+		}
+		pool.all = append(pool.all, cli)
+		pool.clients <- cli
+	}
+	return pool, nil
+}
+
+func (p *clientPool) size() int {
+	return len(p.all)
+}
+
+func (p *clientPool) get() *client.Client {
+	return <-p.clients
+}
+
+func (p *clientPool) put(cli *client.Client) {
+	p.clients <- cli
+}
+
+func (p *clientPool) Close() error {
+	for _, cli := range p.all {
+		_ = cli.Close()
+	}
+	return nil
+}
+
+// warnInsecureRegistries logs registries marked insecure in the config that
+// the Docker daemon would still contact over TLS. The daemon only allows plain
+// HTTP for registries listed in "insecure-registries" of its daemon.json.
+func warnInsecureRegistries(ctx context.Context, cli *client.Client, auths map[string]RegistryAuth, logger *slog.Logger) {
+	info, err := cli.Info(ctx)
+	if err != nil || info.RegistryConfig == nil {
+		return
+	}
+	for registry, auth := range auths {
+		if !auth.Insecure {
+			continue
+		}
+		host, _, _ := strings.Cut(registry, "/")
+		if index, ok := info.RegistryConfig.IndexConfigs[host]; ok && !index.Secure {
+			continue
+		}
+		if isLoopbackHost(host) {
+			continue
+		}
+		logger.Warn("Registry is insecure in the config but not in the Docker daemon, add it to insecure-registries in daemon.json", "registry", host)
+	}
+}
+
+// isLoopbackHost reports whether host, with an optional port, is a loopback
+// address, which the daemon always treats as insecure.
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// newTestPool returns a pool of size clients talking to the daemon at host.
+func newTestPool(t *testing.T, host string, size int) *clientPool {
+	t.Helper()
+	t.Setenv("DOCKER_HOST", host)
+	pool, err := newClientPool(&Config{Concurrency: size}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func TestClientPoolSizeBound(t *testing.T) {
+	pool := newTestPool(t, "tcp://127.0.0.1:2375", 3)
+	defer pool.Close()
+	if n := pool.size(); n != 3 {
+		t.Fatalf("size() = %d, want 3", n)
+	}
+
+	held := make(map[*client.Client]bool)
+	for i := 0; i < pool.size(); i++ {
+		held[pool.get()] = true
+	}
+	if len(held) != 3 {
+		t.Fatalf("got %d distinct clients, want 3", len(held))
+	}
+
+	got := make(chan *client.Client)
+	go func() { got <- pool.get() }()
+	select {
+	case <-got:
+		t.Fatal("get() returned a client while all were in use")
+	case <-time.After(50 * time.Millisecond):
+	}
+	for cli := range held {
+		pool.put(cli)
+		break
+	}
+	select {
+	case cli := <-got:
+		if !held[cli] {
+			t.Error("get() returned a client not from the pool")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("get() still blocked after put()")
+	}
+}
+
+func TestClientPoolConcurrentUse(t *testing.T) {
+	pool := newTestPool(t, "tcp://127.0.0.1:2375", 4)
+	defer pool.Close()
+
+	var mu sync.Mutex
+	inUse := make(map[*client.Client]bool)
+	peak := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				cli := pool.get()
+				mu.Lock()
+				if inUse[cli] {
+					t.Error("client handed out twice")
+				}
+				inUse[cli] = true
+				peak = max(peak, len(inUse))
+				mu.Unlock()
+
+				time.Sleep(100 * time.Microsecond)
+
+				mu.Lock()
+				delete(inUse, cli)
+				mu.Unlock()
+				pool.put(cli)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak > pool.size() {
+		t.Errorf("%d clients in use at once, pool size is %d", peak, pool.size())
+	}
+	if n := len(pool.clients); n != pool.size() {
+		t.Errorf("%d clients returned to the pool, want %d", n, pool.size())
+	}
+}
+
+// TestClientPoolClose checks that Close drops the idle daemon connections
+// of every client.
+func TestClientPoolClose(t *testing.T) {
+	closed := make(chan struct{}, 8)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", "1.45")
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	pool := newTestPool(t, "tcp://"+srv.Listener.Addr().String(), 2)
+	for _, cli := range pool.all {
+		if _, e := cli.Ping(context.Background()); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if e := pool.Close(); e != nil {
+		t.Fatalf("Close() = %v", e)
+	}
+	for i := 0; i < pool.size(); i++ {
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatalf("%d of %d daemon connections closed", i, pool.size())
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	stepPull = "pull"
+	stepTag  = "tag"
+	stepPush = "push"
+)
+
+type PullError struct {
+	Image string
+	Step  string
+	Cause error
+}
+
+func (e *PullError) Error() string {
+	return fmt.Sprintf("pull image %s failed: %v", e.Image, e.Cause)
+}
+
+func (e *PullError) Unwrap() error {
+	return e.Cause
+}
+
+type TagError struct {
+	Image  string
+	Target string
+	Step   string
+	Cause  error
+}
+
+func (e *TagError) Error() string {
+	return fmt.Sprintf("tag image %s to %s failed: %v", e.Image, e.Target, e.Cause)
+}
+
+func (e *TagError) Unwrap() error {
+	return e.Cause
+}
+
+type PushError struct {
+	Image string
+	Step  string
+	Cause error
+}
+
+func (e *PushError) Error() string {
+	return fmt.Sprintf("push image %s failed: %v", e.Image, e.Cause)
+}
+
+func (e *PushError) Unwrap() error {
+	return e.Cause
+}
+
+// failureStep returns the sync step an error originated from, or "other".
+func failureStep(err error) string {
+	var pullErr *PullError
+	var tagErr *TagError
+	var pushErr *PushError
+	switch {
+	case errors.As(err, &pullErr):
+		return pullErr.Step
+	case errors.As(err, &tagErr):
+		return tagErr.Step
+	case errors.As(err, &pushErr):
+		return pushErr.Step
+	default:
+		return "other"
+	}
+}
+
+// summarizeFailures formats failure counts per step, e.g. "pull=2 push=1".
+func summarizeFailures(results []*imageResult) string {
+	counts := make(map[string]int)
+	for _, r := range results {
+		if r.Err != nil {
+			counts[failureStep(r.Err)]++
+		}
+	}
+	steps := make([]string, 0, len(counts))
+	for step, n := range counts {
+		steps = append(steps, fmt.Sprintf("%s=%d", step, n))
+	}
+	sort.Strings(steps)
+	return strings.Join(steps, " ")
+}
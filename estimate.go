@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/docker/go-units"
+)
+
+// printSizeEstimate writes the compressed bytes each image would download
+// from its source and upload to its target, layers shared between images
+// counted once, and reports whether every image could be estimated.
+func (s *syncer) printSizeEstimate(ctx context.Context, w io.Writer) bool {
+	pulled := make(map[string]bool)
+	pushed := make(map[string]bool)
+	var download, upload int64
+	ok := true
+	for _, img := range s.resolvedImages(ctx) {
+		src, err := parseImageRef(img.Source)
+		if err != nil {
+			ok = false
+			fmt.Fprintf(w, "error %s -> %s: %v\n", img.Source, img.Target, err)
+			continue
+		}
+		manifest, err := s.manifestLayers(ctx, src)
+		if err != nil {
+			ok = false
+			fmt.Fprintf(w, "error %s -> %s: %v\n", img.Source, img.Target, err)
+			continue
+		}
+		var dst *imageRef
+		if _, archive := archivePath(img.Target); !archive {
+			if dst, err = parseImageRef(img.Target); err != nil {
+				ok = false
+				fmt.Fprintf(w, "error %s -> %s: %v\n", img.Source, img.Target, err)
+				continue
+			}
+		}
+		var imgDownload, imgUpload int64
+		for _, layer := range manifest.Layers {
+			digest := layer.Digest
+			if !pulled[digest] {
+				pulled[digest] = true
+				imgDownload += layer.Size
+			}
+			if dst == nil || pushed[dst.Domain+"/"+digest] {
+				continue
+			}
+			pushed[dst.Domain+"/"+digest] = true
+			if exists, e := s.registry.blobExists(ctx, dst, digest); e != nil {
+				s.logger.Warn("Failed to check target layer, counting it as missing", "image", img.Target, "digest", digest, "error", e)
+			} else if exists {
+				continue
+			}
+			imgUpload += layer.Size
+		}
+		download += imgDownload
+		upload += imgUpload
+		fmt.Fprintf(w, "%s -> %s: download %s, upload %s\n", img.Source, img.Target, units.BytesSize(float64(imgDownload)), units.BytesSize(float64(imgUpload)))
+	}
+	fmt.Fprintf(w, "total: download %s (%d bytes), upload %s (%d bytes)\n", units.BytesSize(float64(download)), download, units.BytesSize(float64(upload)), upload)
+	return ok
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const etcdTimeout = 10 * time.Second
+
+// EtcdConfig stores the sync config and state in an etcd cluster instead of
+// local files, so several nodes can share them.
+type EtcdConfig struct {
+	Endpoints []string `json:"endpoints" jsonschema_description:"etcd endpoints, e.g. http://etcd:2379"`
+	Prefix    string   `json:"prefix" jsonschema_description:"Key prefix holding <prefix>/config and <prefix>/state/<image-hash>"`
+}
+
+func (c *EtcdConfig) enabled() bool {
+	return c != nil && len(c.Endpoints) > 0
+}
+
+func (c *EtcdConfig) configKey() string {
+	return strings.TrimSuffix(c.Prefix, "/") + "/config"
+}
+
+func (c *EtcdConfig) stateKey(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return c.statePrefix() + hex.EncodeToString(sum[:])
+}
+
+func (c *EtcdConfig) statePrefix() string {
+	return strings.TrimSuffix(c.Prefix, "/") + "/state/"
+}
+
+// withEtcd calls fn with a client connected to the cluster.
+func withEtcd(c *EtcdConfig, fn func(ctx context.Context, cli *clientv3.Client) error) error {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   c.Endpoints,
+		DialTimeout: etcdTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create etcd client: %w", err)
+	}
+	defer cli.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	return fn(ctx, cli)
+}
+
+// fetchEtcdConfig reads the JSON config stored at <prefix>/config.
+func fetchEtcdConfig(c *EtcdConfig) ([]byte, error) {
+	var body []byte
+	err := withEtcd(c, func(ctx context.Context, cli *clientv3.Client) error {
+		resp, e := cli.Get(ctx, c.configKey())
+		if e != nil {
+			return e
+		}
+		if len(resp.Kvs) == 0 {
+			return fmt.Errorf("etcd key %s not found", c.configKey())
+		}
+		body = resp.Kvs[0].Value
+		return nil
+	})
+	return body, err
+}
+
+// loadEtcdState reads the state of every image stored under <prefix>/state/.
+func loadEtcdState(c *EtcdConfig) (*SyncState, error) {
+	state := &SyncState{Images: make(map[string]*ImageState)}
+	err := withEtcd(c, func(ctx context.Context, cli *clientv3.Client) error {
+		resp, e := cli.Get(ctx, c.statePrefix(), clientv3.WithPrefix())
+		if e != nil {
+			return e
+		}
+		for _, kv := range resp.Kvs {
+			entry := &ImageState{}
+			if e = json.Unmarshal(kv.Value, entry); e != nil {
+				return fmt.Errorf("failed to parse state %s: %w", kv.Key, e)
+			}
+			state.Images[entry.Source] = entry
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+	return state, nil
+}
+
+// saveEtcdState writes the state of every image to <prefix>/state/<image-hash>.
+func saveEtcdState(c *EtcdConfig, state *SyncState) error {
+	err := withEtcd(c, func(ctx context.Context, cli *clientv3.Client) error {
+		for source, entry := range state.Images {
+			data, e := json.Marshal(entry)
+			if e != nil {
+				return e
+			}
+			if _, e = cli.Put(ctx, c.stateKey(source), string(data)); e != nil {
+				return e
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	return nil
+}
+
+// clearEtcdState deletes every image state under <prefix>/state/.
+func clearEtcdState(c *EtcdConfig) error {
+	return withEtcd(c, func(ctx context.Context, cli *clientv3.Client) error {
+		_, e := cli.Delete(ctx, c.statePrefix(), clientv3.WithPrefix())
+		return e
+	})
+}
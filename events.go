@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType names a phase of the sync published on the EventBus.
+type EventType string
+
+const (
+	ImagePullStarted   EventType = "image_pull_started"
+	ImagePullCompleted EventType = "image_pull_completed"
+	ImagePushCompleted EventType = "image_push_completed"
+	ImageSyncFailed    EventType = "image_sync_failed"
+	CycleCompleted     EventType = "cycle_completed"
+)
+
+// Event describes a sync phase. Image is the reference the phase worked on,
+// Err is set when the phase failed, and Results holds the results of the
+// cycle for CycleCompleted.
+type Event struct {
+	Type    EventType
+	Image   string
+	Start   time.Time
+	Err     error
+	Result  *imageResult
+	Results []*imageResult
+}
+
+// EventBus calls the handlers subscribed to an event type synchronously, in
+// the order they subscribed, so side effects like metrics, notifications
+// and the audit log stay out of the sync code.
+type EventBus struct {
+	mu       sync.RWMutex
+	handlers map[EventType][]func(Event)
+}
+
+func newEventBus() *EventBus {
+	return &EventBus{handlers: make(map[EventType][]func(Event))}
+}
+
+func (b *EventBus) Subscribe(eventType EventType, handler func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// Publish calls the handlers of ev.Type. It is safe to call on a nil bus.
+func (b *EventBus) Publish(ev Event) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	handlers := b.handlers[ev.Type]
+	b.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(ev)
+	}
+}
+
+// subscribeHandlers registers the audit, metric and notification handlers.
+func (s *syncer) subscribeHandlers() {
+	s.events.Subscribe(ImagePullCompleted, func(ev Event) {
+		s.audit.record("pull", ev.Image, ev.Start, ev.Err)
+	})
+	s.events.Subscribe(ImagePushCompleted, func(ev Event) {
+		s.audit.record("push", ev.Image, ev.Start, ev.Err)
+	})
+	s.events.Subscribe(CycleCompleted, func(ev Event) {
+		for _, r := range ev.Results {
+			s.recordImageHistory(ev.Start, r)
+		}
+		s.recordSyncHistory(ev.Start)
+	})
+	s.events.Subscribe(CycleCompleted, func(ev Event) {
+		if s.config.InfluxDB == nil {
+			return
+		}
+		if e := writeInfluxMetrics(s.config.InfluxDB, ev.Results); e != nil {
+			s.logger.Error("Error exporting metrics", "error", e)
+		}
+	})
+	s.events.Subscribe(CycleCompleted, func(Event) {
+		s.notifyCycle()
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// unexpiredImages drops the images whose ExpiresAt has passed, deleting
+// their targets when DeleteExpired is set. Images with an invalid ExpiresAt
+// keep syncing.
+func (s *syncer) unexpiredImages(ctx context.Context, images []ImageConfig) []ImageConfig {
+	now := time.Now()
+	active := make([]ImageConfig, 0, len(images))
+	for _, img := range images {
+		if img.ExpiresAt == "" {
+			active = append(active, img)
+			continue
+		}
+		expiresAt, err := time.Parse(time.RFC3339, img.ExpiresAt)
+		if err != nil {
+			s.logger.Error("Invalid expires_at, expected RFC3339", "image", img.Source, "expires_at", img.ExpiresAt, "error", err)
+			active = append(active, img)
+			continue
+		}
+		if now.Before(expiresAt) {
+			active = append(active, img)
+			continue
+		}
+		s.logger.Info("image expired, no longer syncing", "image", img.Source, "expires_at", img.ExpiresAt)
+		if img.DeleteExpired {
+			if e := s.deleteExpired(ctx, &img); e != nil {
+				s.logger.Error("Failed to delete expired image", "image", img.Target, "error", e)
+			}
+		}
+	}
+	return active
+}
+
+// deleteExpired deletes the target tag of an expired image from the target
+// registry, if it still exists and no other tag points to its digest.
+func (s *syncer) deleteExpired(ctx context.Context, img *ImageConfig) error {
+	if _, archive := archivePath(img.Target); archive {
+		return nil
+	}
+	target, err := resolveTarget(img.Source, img.Target, s.config.NamespaceMappings)
+	if err != nil {
+		return err
+	}
+	ref, err := parseImageRef(affixTag(target, img.TargetTagPrefix, img.TargetTagSuffix))
+	if err != nil {
+		return err
+	}
+	info, ok, err := s.registry.headManifest(ctx, ref)
+	if err != nil || !ok {
+		return err
+	}
+
+	// Deleting the manifest removes every tag pointing to it, such as a
+	// release tag promoted from an expired release candidate.
+	tags, err := s.registry.listTags(ctx, ref)
+	if err != nil {
+		return err
+	}
+	tagged, err := s.taggedDigests(ctx, ref, tags, func(tag string) bool { return tag != ref.Reference })
+	if err != nil {
+		return err
+	}
+	if tagged[info.Digest] {
+		s.logger.Info("Keeping expired image, its digest is still tagged by another tag", "image", ref.String(), "digest", info.Digest)
+		return nil
+	}
+	start := time.Now()
+	err = s.registry.deleteManifest(ctx, ref, info.Digest)
+	s.audit.record("delete", ref.String(), start, err)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("deleted expired image", "image", ref.String(), "digest", info.Digest)
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+type imageMetrics struct {
+	Source       string     `json:"source"`
+	Target       string     `json:"target"`
+	LastSyncedAt *time.Time `json:"last_synced_at"`
+	Digest       string     `json:"digest"`
+	Stale        bool       `json:"stale"`
+}
+
+// exportMetrics prints the staleness of every configured image as JSON and
+// reports whether any of them is stale.
+func exportMetrics(config *Config, staleAfter time.Duration) (bool, error) {
+	if !config.hasState() {
+		return false, fmt.Errorf("state_file or etcd is not configured")
+	}
+	state, err := config.loadState()
+	if err != nil {
+		return false, err
+	}
+	if staleAfter <= 0 {
+		staleAfter = 2 * time.Duration(config.Duration) * time.Second
+	}
+
+	var output struct {
+		Images []imageMetrics `json:"images"`
+	}
+	anyStale := false
+	for _, img := range config.Images {
+		m := imageMetrics{Source: img.Source, Target: img.Target, Stale: true}
+		if entry, ok := state.Images[img.Source]; ok && !entry.LastSyncedAt.IsZero() {
+			synced := entry.LastSyncedAt
+			m.LastSyncedAt = &synced
+			m.Digest = entry.Digest
+			m.Stale = time.Since(synced) > staleAfter
+		}
+		anyStale = anyStale || m.Stale
+		output.Images = append(output.Images, m)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return anyStale, encoder.Encode(output)
+}
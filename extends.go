@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const maxExtendsDepth = 5
+
+// readExtendedConfig reads the config at path as JSON, deep-merged over the
+// chain of base configs named by "extends". Images are appended to the
+// images of the base, objects such as auths are merged and other fields
+// override the base.
+func readExtendedConfig(path string, opts *loadOptions) ([]byte, error) {
+	return readExtends(path, opts, nil)
+}
+
+func readExtends(path string, opts *loadOptions, chain []string) ([]byte, error) {
+	if u, err := url.Parse(path); err != nil || u.Scheme == "" {
+		if abs, e := filepath.Abs(path); e == nil {
+			path = abs
+		}
+	}
+	if slices.Contains(chain, path) {
+		return nil, fmt.Errorf("config extends itself: %s", strings.Join(append(chain, path), " -> "))
+	}
+	if len(chain) > maxExtendsDepth {
+		return nil, fmt.Errorf("config extends more than %d levels: %s", maxExtendsDepth, strings.Join(chain, " -> "))
+	}
+	body, err := readConfig(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	config, err := decodeConfigMap(body)
+	if err != nil {
+		return body, nil
+	}
+	extends, _ := config["extends"].(string)
+	if extends == "" {
+		return body, nil
+	}
+
+	// Bases are read with their own format and must not replace the version
+	// of the watched config.
+	var baseOpts loadOptions
+	if opts != nil {
+		baseOpts = *opts
+		baseOpts.Format = ""
+	}
+	baseBody, err := readExtends(resolveExtends(path, extends), &baseOpts, append(chain, path))
+	if err != nil {
+		return nil, err
+	}
+	base, err := decodeConfigMap(baseBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", extends, err)
+	}
+	delete(config, "extends")
+	return json.Marshal(mergeConfigMaps(base, config, true))
+}
+
+func decodeConfigMap(body []byte) (map[string]any, error) {
+	var config map[string]any
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// resolveExtends resolves a base config path relative to the config
+// extending it, which may be a URL or a local file.
+func resolveExtends(path, extends string) string {
+	if u, err := url.Parse(extends); err == nil && u.Scheme != "" || filepath.IsAbs(extends) {
+		return extends
+	}
+	if u, err := url.Parse(path); err == nil && u.Scheme != "" {
+		ref, e := url.Parse(extends)
+		if e != nil {
+			return extends
+		}
+		return u.ResolveReference(ref).String()
+	}
+	return filepath.Join(filepath.Dir(path), extends)
+}
+
+// mergeConfigMaps merges override over base. At the top level images are
+// appended rather than replaced.
+func mergeConfigMaps(base, override map[string]any, top bool) map[string]any {
+	for key, value := range override {
+		switch v := value.(type) {
+		case map[string]any:
+			if b, ok := base[key].(map[string]any); ok {
+				base[key] = mergeConfigMaps(b, v, false)
+				continue
+			}
+		case []any:
+			if b, ok := base[key].([]any); ok && top && key == "images" {
+				base[key] = append(b, v...)
+				continue
+			}
+		}
+		base[key] = value
+	}
+	return base
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// imageFlags collects repeated -image source=<src>,target=<tgt> flags.
+type imageFlags []ImageConfig
+
+func (f *imageFlags) String() string {
+	pairs := make([]string, 0, len(*f))
+	for _, img := range *f {
+		pairs = append(pairs, "source="+img.Source+",target="+img.Target)
+	}
+	return strings.Join(pairs, " ")
+}
+
+func (f *imageFlags) Set(value string) error {
+	var img ImageConfig
+	for _, part := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return fmt.Errorf("invalid image %q, expected source=<src>,target=<tgt>", value)
+		}
+		switch key {
+		case "source":
+			img.Source = val
+		case "target":
+			img.Target = val
+		default:
+			return fmt.Errorf("unknown image key %q", key)
+		}
+	}
+	if img.Source == "" || img.Target == "" {
+		return fmt.Errorf("invalid image %q, both source and target are required", value)
+	}
+	*f = append(*f, img)
+	return nil
+}
+
+// imageFilters collects repeated -image-filter label=<key>[=<value>] flags.
+type imageFilters []string
+
+func (f *imageFilters) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *imageFilters) Set(value string) error {
+	kind, label, _ := strings.Cut(value, "=")
+	if kind != "label" || label == "" || strings.HasPrefix(label, "=") {
+		return fmt.Errorf("invalid image filter %q, expected label=<key>[=<value>]", value)
+	}
+	*f = append(*f, label)
+	return nil
+}
+
+// match reports whether labels contain every filtered label, with the
+// filtered value when one is given.
+func (f imageFilters) match(labels map[string]string) bool {
+	for _, filter := range f {
+		key, want, hasValue := strings.Cut(filter, "=")
+		got, ok := labels[key]
+		if !ok || hasValue && got != want {
+			return false
+		}
+	}
+	return true
+}
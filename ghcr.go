@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const githubAPI = "https://api.github.com"
+
+type ghcrPackage struct {
+	Name string `json:"name"`
+}
+
+type ghcrVersion struct {
+	Metadata struct {
+		Container struct {
+			Tags []string `json:"tags"`
+		} `json:"container"`
+	} `json:"metadata"`
+}
+
+// githubGet fetches and concatenates every page of a GitHub API list endpoint.
+func githubGet[T any](ctx context.Context, token, endpoint string) ([]T, error) {
+	var all []T
+	for page := 1; ; page++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s%sper_page=100&page=%d", endpoint, querySep(endpoint), page), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var items []T
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("GET %s failed: %s", endpoint, resp.Status)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&items)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode %s failed: %w", endpoint, err)
+		}
+		all = append(all, items...)
+		if len(items) < 100 {
+			return all, nil
+		}
+	}
+}
+
+func querySep(endpoint string) string {
+	if strings.Contains(endpoint, "?") {
+		return "&"
+	}
+	return "?"
+}
+
+// expandGHCR lists all container packages of a GitHub user or organization
+// and returns one image per tag, mirrored below img.Target.
+func expandGHCR(ctx context.Context, logger *slog.Logger, img ImageConfig) ([]ImageConfig, error) {
+	owner, base := img.GHCROrg, githubAPI+"/orgs/"
+	if owner == "" {
+		owner, base = img.GHCRUser, githubAPI+"/users/"
+	}
+	base += url.PathEscape(owner) + "/packages"
+	packages, err := githubGet[ghcrPackage](ctx, img.GHCRToken, base+"?package_type=container")
+	if err != nil {
+		return nil, err
+	}
+	targetPrefix := strings.TrimSuffix(img.Target, "/")
+	var images []ImageConfig
+	for _, pkg := range packages {
+		versions, e := githubGet[ghcrVersion](ctx, img.GHCRToken, base+"/container/"+url.PathEscape(pkg.Name)+"/versions")
+		if e != nil {
+			return nil, fmt.Errorf("list versions of %s failed: %w", pkg.Name, e)
+		}
+		for _, v := range versions {
+			for _, tag := range v.Metadata.Container.Tags {
+				item := img
+				item.GHCROrg, item.GHCRUser, item.GHCRToken = "", "", ""
+				item.Source = "ghcr.io/" + strings.ToLower(owner) + "/" + pkg.Name + ":" + tag
+				item.Target = targetPrefix + "/" + pkg.Name + ":" + tag
+				images = append(images, item)
+			}
+		}
+	}
+	logger.Info("discovered ghcr.io packages", "owner", owner, "tags", len(images), "packages", len(packages))
+	return images, nil
+}
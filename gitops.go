@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// gitopsMaxTags is how many of the newest tags are mirrored per Flux image
+// policy, so the tag Flux selects next is already in the target registry.
+const gitopsMaxTags = 3
+
+// fluxResource holds the fields of Flux ImageRepository and ImagePolicy
+// resources used to build the sync list.
+type fluxResource struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		Image              string `json:"image"`
+		ImageRepositoryRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"imageRepositoryRef"`
+		Policy struct {
+			SemVer       *struct{} `json:"semver"`
+			Alphabetical *struct{} `json:"alphabetical"`
+			Numerical    *struct{} `json:"numerical"`
+		} `json:"policy"`
+	} `json:"spec"`
+}
+
+// discoverGitOpsImages walks a local GitOps repository for Flux image
+// automation resources and returns one image per ImagePolicy, mirroring the
+// newest tags of its ImageRepository below targetRegistry.
+func discoverGitOpsImages(logger *slog.Logger, dir, targetRegistry string) ([]ImageConfig, error) {
+	if targetRegistry == "" {
+		return nil, fmt.Errorf("a target registry is required for gitops discovery")
+	}
+	repositories := make(map[string]string)
+	var policies []fluxResource
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := filepath.Ext(path); ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+		resources, e := readFluxResources(path)
+		if e != nil {
+			logger.Warn("Skipping unreadable manifest", "path", path, "error", e)
+			return nil
+		}
+		for _, r := range resources {
+			switch r.Kind {
+			case "ImageRepository":
+				repositories[r.Metadata.Namespace+"/"+r.Metadata.Name] = r.Spec.Image
+			case "ImagePolicy":
+				policies = append(policies, r)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gitops repository: %w", err)
+	}
+
+	prefix := strings.TrimSuffix(targetRegistry, "/")
+	seen := make(map[string]bool)
+	var images []ImageConfig
+	for _, p := range policies {
+		namespace := p.Spec.ImageRepositoryRef.Namespace
+		if namespace == "" {
+			namespace = p.Metadata.Namespace
+		}
+		source, ok := repositories[namespace+"/"+p.Spec.ImageRepositoryRef.Name]
+		if !ok {
+			logger.Warn("Image policy references an unknown image repository", "policy", p.Metadata.Name, "repository", p.Spec.ImageRepositoryRef.Name)
+			continue
+		}
+		ref, e := parseImageRef(source)
+		if e != nil {
+			logger.Warn("Skipping image repository", "image", source, "error", e)
+			continue
+		}
+		order := tagSortAlpha
+		if p.Spec.Policy.SemVer != nil {
+			order = tagSortSemver
+		}
+		if seen[source+" "+order] {
+			continue
+		}
+		seen[source+" "+order] = true
+		images = append(images, ImageConfig{
+			Source:       source + ":*",
+			Target:       prefix + "/" + ref.Repository,
+			TagSortOrder: order,
+			MaxImages:    gitopsMaxTags,
+		})
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].Source < images[j].Source })
+	logger.Info("discovered images in gitops repository", "images", len(images), "policies", len(policies))
+	return images, nil
+}
+
+// readFluxResources decodes every YAML document of a file.
+func readFluxResources(path string) ([]fluxResource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec := yaml.NewYAMLOrJSONDecoder(f, 4096)
+	var resources []fluxResource
+	for {
+		var r fluxResource
+		if e := dec.Decode(&r); e != nil {
+			if errors.Is(e, io.EOF) {
+				return resources, nil
+			}
+			return resources, e
+		}
+		resources = append(resources, r)
+	}
+}
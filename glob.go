@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/mod/semver"
+)
+
+const (
+	tagSortAlpha  = "alpha"
+	tagSortSemver = "semver"
+	tagSortDate   = "date"
+)
+
+// splitTag splits "name:tag" into its repository and tag. It does not
+// validate the tag so it also works with glob patterns.
+func splitTag(ref string) (string, string) {
+	i := strings.LastIndex(ref, ":")
+	if i < 0 || strings.Contains(ref[i:], "/") {
+		return ref, ""
+	}
+	return ref[:i], ref[i+1:]
+}
+
+func isTagGlob(tag string) bool {
+	return strings.ContainsAny(tag, "*?[")
+}
+
+// tagTarget returns target with the tag a glob source matched. Archive
+// targets and targets using the source placeholder are kept as they are.
+func tagTarget(target, tag string) string {
+	if _, archive := archivePath(target); archive || strings.Contains(target, sourcePlaceholder) {
+		return target
+	}
+	targetRepo, _ := splitTag(target)
+	return targetRepo + ":" + tag
+}
+
+// expandImages replaces every image whose source tag is a glob with one image
+// per matching tag, ordered by TagSortOrder and limited to MaxImages.
+func (s *syncer) expandImages(ctx context.Context, images []ImageConfig) []ImageConfig {
+	expanded := make([]ImageConfig, 0, len(images))
+	for _, img := range images {
+		if img.GHCROrg != "" || img.GHCRUser != "" {
+			discovered, err := expandGHCR(ctx, s.logger, img)
+			if err != nil {
+				s.logger.Error("Failed to list ghcr.io packages", "error", err)
+				continue
+			}
+			expanded = append(expanded, discovered...)
+			continue
+		}
+		repo, pattern := splitTag(img.Source)
+		if !isTagGlob(pattern) {
+			expanded = append(expanded, img)
+			continue
+		}
+		tags, err := s.matchTags(ctx, repo, pattern, img.TagSortOrder)
+		if err != nil {
+			s.logger.Error("Failed to expand tags", "image", img.Source, "error", err)
+			continue
+		}
+		if img.MaxImages > 0 && len(tags) > img.MaxImages {
+			tags = tags[:img.MaxImages]
+		}
+		for _, tag := range tags {
+			item := img
+			item.Source = repo + ":" + tag
+			item.Target = tagTarget(img.Target, tag)
+			expanded = append(expanded, item)
+		}
+		s.logger.Info("expanded tags", "image", img.Source, "tags", len(tags))
+	}
+	return expanded
+}
+
+func (s *syncer) matchTags(ctx context.Context, repo, pattern, order string) ([]string, error) {
+	ref, err := parseImageRef(repo)
+	if err != nil {
+		return nil, err
+	}
+	all, err := s.registry.listTags(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, tag := range all {
+		if ok, e := path.Match(pattern, tag); e != nil {
+			return nil, fmt.Errorf("invalid tag pattern %q: %w", pattern, e)
+		} else if ok {
+			tags = append(tags, tag)
+		}
+	}
+
+	switch order {
+	case tagSortSemver:
+		sortTagsBySemver(tags)
+	case tagSortDate:
+		created := make(map[string]time.Time, len(tags))
+		for _, tag := range tags {
+			t, e := s.imageCreated(ctx, ref.withReference(tag))
+			if e != nil {
+				s.logger.Warn("Failed to read creation time", "image", repo+":"+tag, "error", e)
+			}
+			created[tag] = t
+		}
+		sort.SliceStable(tags, func(i, j int) bool {
+			return created[tags[i]].After(created[tags[j]])
+		})
+	case "", tagSortAlpha:
+		sort.Strings(tags)
+	default:
+		return nil, fmt.Errorf("unknown tag sort order %q", order)
+	}
+	return tags, nil
+}
+
+// sortTagsBySemver sorts tags newest first. Tags that are not semantic
+// versions are kept after the versioned ones in lexicographic order.
+func sortTagsBySemver(tags []string) {
+	canonical := func(tag string) string {
+		if !strings.HasPrefix(tag, "v") {
+			tag = "v" + tag
+		}
+		if semver.IsValid(tag) {
+			return tag
+		}
+		return ""
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		a, b := canonical(tags[i]), canonical(tags[j])
+		switch {
+		case a != "" && b != "":
+			return semver.Compare(a, b) > 0
+		case a != "" || b != "":
+			return a != ""
+		default:
+			return tags[i] < tags[j]
+		}
+	})
+}
+
+// imageCreated reads the creation time from the image config blob. For a
+// manifest list the first platform is used.
+func (s *syncer) imageCreated(ctx context.Context, ref *imageRef) (time.Time, error) {
+	info, err := s.registry.getManifest(ctx, ref)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if isIndexMediaType(info.MediaType) {
+		var index ocispec.Index
+		if e := json.Unmarshal(info.Body, &index); e != nil {
+			return time.Time{}, e
+		}
+		if len(index.Manifests) == 0 {
+			return time.Time{}, fmt.Errorf("empty manifest list")
+		}
+		if info, err = s.registry.getManifest(ctx, ref.withReference(index.Manifests[0].Digest.String())); err != nil {
+			return time.Time{}, err
+		}
+	}
+	var manifest ocispec.Manifest
+	if e := json.Unmarshal(info.Body, &manifest); e != nil {
+		return time.Time{}, e
+	}
+	reader, err := s.registry.getBlob(ctx, ref, manifest.Config.Digest.String())
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer reader.Close()
+	var config ocispec.Image
+	if e := json.NewDecoder(io.LimitReader(reader, 10<<20)).Decode(&config); e != nil {
+		return time.Time{}, e
+	}
+	if config.Created == nil {
+		return time.Time{}, nil
+	}
+	return *config.Created, nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestTagTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"registry.example.com/app:*", "registry.example.com/app:1.2"},
+		{"registry.example.com/app", "registry.example.com/app:1.2"},
+		{"tar://out.tar", "tar://out.tar"},
+		{"registry.example.com/" + sourcePlaceholder, "registry.example.com/" + sourcePlaceholder},
+	}
+	for _, tt := range tests {
+		if got := tagTarget(tt.target, "1.2"); got != tt.want {
+			t.Errorf("tagTarget(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS sync_history (
+	cycle_start       TIMESTAMP NOT NULL,
+	cycle_duration_ms INTEGER NOT NULL,
+	images_total      INTEGER NOT NULL,
+	images_success    INTEGER NOT NULL,
+	images_error      INTEGER NOT NULL,
+	bytes_pulled      INTEGER NOT NULL,
+	bytes_pushed      INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS image_history (
+	cycle_start TIMESTAMP NOT NULL,
+	finished_at TIMESTAMP NOT NULL,
+	source      TEXT NOT NULL,
+	target      TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	error       TEXT,
+	digest      TEXT,
+	bytes       INTEGER NOT NULL,
+	pull_ms     INTEGER NOT NULL,
+	push_ms     INTEGER NOT NULL
+);`
+
+// openHistory opens the SQLite database keeping the sync history, creating
+// its tables when missing.
+func openHistory(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("open metrics database failed: %w", err)
+	}
+	if _, e := db.Exec(historySchema); e != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("create metrics tables failed: %w", e)
+	}
+	return db, nil
+}
+
+// recordImageHistory writes the outcome of one image of the cycle started at
+// cycleStart. It is a no-op without a metrics database.
+func (s *syncer) recordImageHistory(cycleStart time.Time, r *imageResult) {
+	if s.history == nil {
+		return
+	}
+	var errText string
+	if r.Err != nil {
+		errText = r.Err.Error()
+	}
+	_, e := s.history.Exec(`INSERT INTO image_history VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		cycleStart.UTC(), r.FinishedAt.UTC(), r.Source, r.Target, r.Status, errText, r.Digest, r.Bytes,
+		r.PullDuration.Milliseconds(), r.PushDuration.Milliseconds())
+	if e != nil {
+		s.logger.Error("Failed to write image history", "image", r.Source, "error", e)
+	}
+}
+
+// recordSyncHistory writes the totals of the cycle started at cycleStart.
+func (s *syncer) recordSyncHistory(cycleStart time.Time) {
+	if s.history == nil {
+		return
+	}
+	var success, failed int
+	var pulled, pushed int64
+	for _, r := range s.results {
+		if r.PullDuration > 0 {
+			pulled += r.Bytes
+		}
+		switch r.Status {
+		case statusSuccess:
+			success++
+			pushed += r.Bytes
+		case statusFailed:
+			failed++
+		}
+	}
+	_, e := s.history.Exec(`INSERT INTO sync_history VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		cycleStart.UTC(), time.Since(cycleStart).Milliseconds(), len(s.results), success, failed, pulled, pushed)
+	if e != nil {
+		s.logger.Error("Failed to write sync history", "error", e)
+	}
+}
+
+// printHistoryReport writes the last n cycles of the metrics database as a table.
+func printHistoryReport(path string, n int, w io.Writer) error {
+	db, err := openHistory(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT cycle_start, cycle_duration_ms, images_total, images_success, images_error, bytes_pulled, bytes_pushed
+		FROM sync_history ORDER BY cycle_start DESC LIMIT ?`, n)
+	if err != nil {
+		return fmt.Errorf("query sync history failed: %w", err)
+	}
+	defer rows.Close()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CYCLE START\tDURATION\tTOTAL\tSUCCESS\tERRORS\tPULLED\tPUSHED")
+	for rows.Next() {
+		var start time.Time
+		var durationMs, pulled, pushed int64
+		var total, success, failed int
+		if e := rows.Scan(&start, &durationMs, &total, &success, &failed, &pulled, &pushed); e != nil {
+			return e
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%d\n", start.Local().Format(time.RFC3339),
+			(time.Duration(durationMs) * time.Millisecond).String(), total, success, failed, pulled, pushed)
+	}
+	if e := rows.Err(); e != nil {
+		return e
+	}
+	return tw.Flush()
+}
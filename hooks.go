@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// runPreSyncHook executes the image's pre-sync hook and reports whether the
+// image should be synced in this cycle.
+func runPreSyncHook(logger *slog.Logger, img *ImageConfig) (bool, error) {
+	if img.PreSyncHook == "" {
+		return true, nil
+	}
+	cmd := exec.Command("sh", "-c", img.PreSyncHook)
+	cmd.Env = append(os.Environ(),
+		"SYNC_SOURCE="+img.Source,
+		"SYNC_TARGET="+img.Target,
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			logger.Info("pre-sync hook exited with non-zero code, skipping", "image", img.Source, "code", exitErr.ExitCode(), "output", strings.TrimSpace(string(output)))
+			return false, nil
+		}
+		return false, fmt.Errorf("run pre-sync hook for %s failed: %w", img.Source, err)
+	}
+	return true, nil
+}
+
+// runTransform executes the image's transform command on the tagged target
+// image before it is pushed. A non-zero exit blocks the push.
+func runTransform(logger *slog.Logger, img *ImageConfig) error {
+	cmd := exec.Command("sh", "-c", img.Transform)
+	cmd.Env = append(os.Environ(),
+		"IMAGE="+img.Target,
+		"SYNC_SOURCE="+img.Source,
+		"SYNC_TARGET="+img.Target,
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("transform %s failed: %w: %s", img.Target, err, strings.TrimSpace(string(output)))
+	}
+	logger.Info("transform image success", "image", img.Target)
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+type InfluxDBConfig struct {
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Org    string `json:"org"`
+	Bucket string `json:"bucket"`
+}
+
+// writeInfluxMetrics writes one point per image synced in the cycle.
+func writeInfluxMetrics(config *InfluxDBConfig, results []*imageResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+	cli := influxdb2.NewClientWithOptions(config.URL, config.Token,
+		influxdb2.DefaultOptions().SetApplicationName("registry-sync/"+BuildVersion))
+	defer cli.Close()
+
+	points := make([]*write.Point, 0, len(results))
+	for _, r := range results {
+		tags := map[string]string{
+			"image_name": r.Source,
+			"status":     r.Status,
+		}
+		if ref, e := parseImageRef(r.Source); e == nil {
+			tags["source_registry"] = ref.Domain
+			tags["image_name"] = ref.Repository
+		}
+		if ref, e := parseImageRef(r.Target); e == nil {
+			tags["target_registry"] = ref.Domain
+		}
+		fields := map[string]interface{}{
+			"pull_duration_ms": r.PullDuration.Milliseconds(),
+			"push_duration_ms": r.PushDuration.Milliseconds(),
+			"bytes":            r.Bytes,
+			"success":          r.Status == statusSuccess,
+		}
+		points = append(points, influxdb2.NewPoint("registry_sync_image", tags, fields, r.FinishedAt))
+	}
+	if e := cli.WriteAPIBlocking(config.Org, config.Bucket).WritePoint(context.Background(), points...); e != nil {
+		return fmt.Errorf("write metrics to influxdb failed: %w", e)
+	}
+	return nil
+}
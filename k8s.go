@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func kubernetesConfig() (*rest.Config, error) {
+	if cfg, err := rest.InClusterConfig(); err == nil {
+		return cfg, nil
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+}
+
+// discoverKubernetesImages lists the pods in namespace (all namespaces when
+// empty) and returns one image per unique container image, mirrored below
+// targetRegistry with the same repository path and tag.
+func discoverKubernetesImages(ctx context.Context, logger *slog.Logger, namespace, targetRegistry string) ([]ImageConfig, error) {
+	if targetRegistry == "" {
+		return nil, fmt.Errorf("a target registry is required for kubernetes discovery")
+	}
+	cfg, err := kubernetesConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubernetes config: %w", err)
+	}
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+	pods, err := cs.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	refs := make(map[string]bool)
+	for _, pod := range pods.Items {
+		for _, c := range podContainers(&pod) {
+			refs[c] = true
+		}
+	}
+	sources := make([]string, 0, len(refs))
+	for ref := range refs {
+		sources = append(sources, ref)
+	}
+	sort.Strings(sources)
+
+	prefix := strings.TrimSuffix(targetRegistry, "/")
+	var images []ImageConfig
+	for _, source := range sources {
+		ref, e := parseImageRef(source)
+		if e != nil {
+			logger.Warn("Skipping discovered image", "image", source, "error", e)
+			continue
+		}
+		if strings.HasPrefix(ref.Reference, "sha256:") {
+			logger.Warn("Skipping discovered image, digest references cannot be tagged in the target", "image", source)
+			continue
+		}
+		if strings.HasPrefix(source, prefix+"/") {
+			continue
+		}
+		images = append(images, ImageConfig{
+			Source: source,
+			Target: prefix + "/" + ref.Repository + ":" + ref.Reference,
+		})
+	}
+	logger.Info("discovered images in pods", "images", len(images), "pods", len(pods.Items))
+	return images, nil
+}
+
+func podContainers(pod *corev1.Pod) []string {
+	var images []string
+	for _, c := range pod.Spec.InitContainers {
+		images = append(images, c.Image)
+	}
+	for _, c := range pod.Spec.Containers {
+		images = append(images, c.Image)
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		images = append(images, c.Image)
+	}
+	return images
+}
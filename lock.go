@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/redis/go-redis/v9"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+const (
+	lockRedis    = "redis"
+	lockPostgres = "postgres"
+	lockEtcd     = "etcd"
+
+	lockKeyPrefix = "registry-sync/lock/"
+)
+
+// DistributedLock makes replicas take an advisory lock per source image
+// before syncing it, so an image is only synced by one of them at a time.
+type DistributedLock struct {
+	Type string `json:"type" jsonschema:"enum=redis,enum=postgres,enum=etcd"`
+
+	// URL is a redis:// URL, a PostgreSQL connection string, or comma
+	// separated etcd endpoints.
+	URL string `json:"url"`
+
+	// TTL releases a redis lock held by a crashed replica, 1h by default.
+	// Postgres and etcd locks are released when the replica disconnects.
+	TTL string `json:"ttl"`
+}
+
+// imageLocker takes the advisory lock of a key. tryLock reports false when
+// another replica holds it.
+type imageLocker interface {
+	tryLock(ctx context.Context, key string) (unlock func(), ok bool, err error)
+	Close() error
+}
+
+func newImageLocker(config *DistributedLock) (imageLocker, error) {
+	switch config.Type {
+	case lockRedis:
+		opts, err := redis.ParseURL(config.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis url: %w", err)
+		}
+		ttl, err := time.ParseDuration(config.TTL)
+		if config.TTL == "" {
+			ttl, err = time.Hour, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid lock ttl: %w", err)
+		}
+		return &redisLocker{client: redis.NewClient(opts), ttl: ttl}, nil
+	case lockPostgres:
+		db, err := sql.Open("pgx", config.URL)
+		if err != nil {
+			return nil, err
+		}
+		return &postgresLocker{db: db}, nil
+	case lockEtcd:
+		cli, err := clientv3.New(clientv3.Config{
+			Endpoints:   strings.Split(config.URL, ","),
+			DialTimeout: etcdTimeout,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create etcd client: %w", err)
+		}
+		return &etcdLocker{client: cli}, nil
+	default:
+		return nil, fmt.Errorf("unknown distributed lock type %q, expected redis, postgres or etcd", config.Type)
+	}
+}
+
+// lockImage takes the distributed lock of a source image. Without a
+// configured lock it always succeeds.
+func (s *syncer) lockImage(ctx context.Context, source string) (func(), bool, error) {
+	if s.locker == nil {
+		return func() {}, true, nil
+	}
+	unlock, ok, err := s.locker.tryLock(ctx, lockKeyPrefix+source)
+	if err != nil {
+		return nil, false, fmt.Errorf("lock image %s failed: %w", source, err)
+	}
+	return unlock, ok, nil
+}
+
+// redisLocker sets a key only if it does not exist, deleting it on unlock
+// only while it still holds this replica's token.
+type redisLocker struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+var redisUnlock = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) end return 0`)
+
+func (l *redisLocker) tryLock(ctx context.Context, key string) (func(), bool, error) {
+	token := fmt.Sprintf("%d", time.Now().UnixNano())
+	ok, err := l.client.SetNX(ctx, key, token, l.ttl).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return func() {
+		_ = redisUnlock.Run(context.Background(), l.client, []string{key}, token).Err()
+	}, true, nil
+}
+
+func (l *redisLocker) Close() error {
+	return l.client.Close()
+}
+
+// postgresLocker takes a session advisory lock, keeping the connection that
+// holds it until unlock.
+type postgresLocker struct {
+	db *sql.DB
+}
+
+func (l *postgresLocker) tryLock(ctx context.Context, key string) (func(), bool, error) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	id := int64(h.Sum64())
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	var ok bool
+	if err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", id).Scan(&ok); err != nil || !ok {
+		_ = conn.Close()
+		return nil, false, err
+	}
+	return func() {
+		_, _ = conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", id)
+		_ = conn.Close()
+	}, true, nil
+}
+
+func (l *postgresLocker) Close() error {
+	return l.db.Close()
+}
+
+// etcdLocker takes an etcd mutex bound to a session lease, which expires
+// when this replica stops.
+type etcdLocker struct {
+	client *clientv3.Client
+}
+
+func (l *etcdLocker) tryLock(ctx context.Context, key string) (func(), bool, error) {
+	session, err := concurrency.NewSession(l.client)
+	if err != nil {
+		return nil, false, err
+	}
+	mutex := concurrency.NewMutex(session, key)
+	if err = mutex.TryLock(ctx); err != nil {
+		_ = session.Close()
+		if errors.Is(err, concurrency.ErrLocked) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return func() {
+		_ = mutex.Unlock(context.Background())
+		_ = session.Close()
+	}, true, nil
+}
+
+func (l *etcdLocker) Close() error {
+	return l.client.Close()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// newLogger returns a logger writing to w in the given format, "text"
+// (default) or "json", that drops records below level.
+func newLogger(w io.Writer, format, level string) *slog.Logger {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	if strings.EqualFold(format, "json") {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// countWarnings returns logger counting its warnings in counter, including
+// those below the logger's level.
+func countWarnings(logger *slog.Logger, counter *atomic.Int64) *slog.Logger {
+	return slog.New(&warnCounter{Handler: logger.Handler(), count: counter})
+}
+
+type warnCounter struct {
+	slog.Handler
+	count *atomic.Int64
+}
+
+func (h *warnCounter) Enabled(ctx context.Context, level slog.Level) bool {
+	return level == slog.LevelWarn || h.Handler.Enabled(ctx, level)
+}
+
+func (h *warnCounter) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level == slog.LevelWarn {
+		h.count.Add(1)
+	}
+	if !h.Handler.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *warnCounter) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &warnCounter{Handler: h.Handler.WithAttrs(attrs), count: h.count}
+}
+
+func (h *warnCounter) WithGroup(name string) slog.Handler {
+	return &warnCounter{Handler: h.Handler.WithGroup(name), count: h.count}
+}
+
+// withoutImageInfo returns logger dropping the records below warning level
+// about a single image, i.e. with an image, source or target attribute.
+func withoutImageInfo(logger *slog.Logger) *slog.Logger {
+	return slog.New(&imageInfoFilter{Handler: logger.Handler()})
+}
+
+type imageInfoFilter struct {
+	slog.Handler
+}
+
+func (h *imageInfoFilter) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelWarn {
+		return h.Handler.Handle(ctx, r)
+	}
+	perImage := false
+	r.Attrs(func(a slog.Attr) bool {
+		perImage = a.Key == "image" || a.Key == "source" || a.Key == "target"
+		return !perImage
+	})
+	if perImage {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *imageInfoFilter) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &imageInfoFilter{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *imageInfoFilter) WithGroup(name string) slog.Handler {
+	return &imageInfoFilter{Handler: h.Handler.WithGroup(name)}
+}
+
+// fatal logs msg at error level and exits.
+func fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNewLoggerText(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, "text", "warn")
+	logger.Info("dropped", "image", "nginx")
+	logger.Warn("kept", "image", "redis")
+
+	out := buf.String()
+	if strings.Contains(out, "dropped") {
+		t.Errorf("info record was not filtered: %q", out)
+	}
+	if !strings.Contains(out, "level=WARN") || !strings.Contains(out, "msg=kept") || !strings.Contains(out, "image=redis") {
+		t.Errorf("warn record missing or without attrs: %q", out)
+	}
+}
+
+func TestNewLoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, "json", "")
+	logger.Info("synced", "image", "nginx", "bytes", 42)
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("output is not JSON: %v: %q", err, buf.String())
+	}
+	if record["level"] != "INFO" || record["msg"] != "synced" || record["image"] != "nginx" || record["bytes"] != float64(42) {
+		t.Errorf("unexpected record %v", record)
+	}
+}
+
+func TestCountWarnings(t *testing.T) {
+	var buf bytes.Buffer
+	counter := new(atomic.Int64)
+	logger := countWarnings(newLogger(&buf, "text", "error"), counter).With("cycle", 1)
+	logger.Info("info")
+	logger.Warn("below level")
+	logger.Warn("below level again")
+	logger.Error("failed")
+
+	if got := counter.Load(); got != 2 {
+		t.Errorf("counted %d warnings, want 2", got)
+	}
+	out := buf.String()
+	if strings.Contains(out, "below level") {
+		t.Errorf("counted warnings below the level were logged: %q", out)
+	}
+	if !strings.Contains(out, "msg=failed") || !strings.Contains(out, "cycle=1") {
+		t.Errorf("error record missing or without attrs: %q", out)
+	}
+}
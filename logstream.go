@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	logBufferSize     = 1000
+	logSubscriberSize = 256
+)
+
+// logBuffer is an io.Writer keeping the last log lines in a ring buffer and
+// fanning them out to the connected log streams.
+type logBuffer struct {
+	mu          sync.Mutex
+	lines       [][]byte
+	next        int
+	subscribers map[chan []byte]struct{}
+}
+
+func newLogBuffer(size int) *logBuffer {
+	return &logBuffer{
+		lines:       make([][]byte, 0, size),
+		subscribers: make(map[chan []byte]struct{}),
+	}
+}
+
+// Write records p as one log line. Subscribers that are not keeping up miss
+// lines rather than blocking the logger.
+func (b *logBuffer) Write(p []byte) (int, error) {
+	line := append([]byte(nil), p...)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.lines) < cap(b.lines) {
+		b.lines = append(b.lines, line)
+	} else {
+		b.lines[b.next] = line
+		b.next = (b.next + 1) % len(b.lines)
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// subscribe returns the buffered lines, oldest first, and a channel receiving
+// the lines written afterwards until cancel is called.
+func (b *logBuffer) subscribe() (backlog [][]byte, lines <-chan []byte, cancel func()) {
+	ch := make(chan []byte, logSubscriberSize)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	backlog = append(append(backlog, b.lines[b.next:]...), b.lines[:b.next]...)
+	b.subscribers[ch] = struct{}{}
+	return backlog, ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, ch)
+	}
+}
+
+// checkOrigin accepts clients that send no Origin header, e.g. websocat, and
+// browsers only on pages of the admin server itself, so other sites cannot
+// read the logs.
+func checkOrigin(_ *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != r.Host {
+		return fmt.Errorf("origin %q not allowed", origin)
+	}
+	return nil
+}
+
+// startAdminServer serves the admin endpoints on addr, streaming logs over a
+// WebSocket at /ws/logs.
+func startAdminServer(addr string, logs *logBuffer, logger *slog.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /ws/logs", websocket.Server{
+		Handshake: checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			backlog, lines, cancel := logs.subscribe()
+			defer cancel()
+			closed := make(chan struct{})
+			go func() {
+				io.Copy(io.Discard, ws)
+				close(closed)
+			}()
+			for _, line := range backlog {
+				if _, e := ws.Write(line); e != nil {
+					return
+				}
+			}
+			for {
+				select {
+				case line := <-lines:
+					if _, e := ws.Write(line); e != nil {
+						return
+					}
+				case <-closed:
+					return
+				}
+			}
+		},
+	})
+	go func() {
+		logger.Info("Serving admin endpoints", "addr", addr)
+		if e := http.ListenAndServe(addr, mux); e != nil {
+			logger.Error("Admin server stopped", "error", e)
+		}
+	}()
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"http://admin.example.com:8081", true},
+		{"https://evil.example.com", false},
+		{"http://admin.example.com", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://admin.example.com:8081/ws/logs", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if err := checkOrigin(nil, r); (err == nil) != tt.ok {
+			t.Errorf("checkOrigin(%q) = %v, want ok %v", tt.origin, err, tt.ok)
+		}
+	}
+}
@@ -2,22 +2,88 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/containerd/containerd"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/term"
 )
 
 var BuildVersion = "dev"
 
+// smokeTestImage is the tiny image synced by -smoke-test.
+const smokeTestImage = "docker.io/library/hello-world:latest"
+
+const verifyPushAttempts = 3
+
 func main() {
 	cfg := flag.String("config", "config.json", "config file")
+	configFormatFlag := flag.String("config-format", "", "config format, json or toml (default from the file extension)")
+	convertConfigFlag := flag.Bool("convert-config", false, "print the config in the -to format and exit")
+	convertTo := flag.String("to", formatTOML, "format for -convert-config, json or toml")
+	configAccept := flag.String("config-accept", os.Getenv("CONFIG_ACCEPT"), "Accept header sent when fetching the config over HTTP")
+	decryptKey := flag.String("decrypt-key", "", "age identity or GPG keyring file used to decrypt .age/.gpg configs")
+	configTimeout := flag.Duration("config-timeout", 30*time.Second, "timeout fetching an HTTP config, 0 for none")
+	gzipConfig := flag.Bool("gzip-config", os.Getenv("GZIP_CONFIG") == "true", "decompress gzip compressed configs, e.g. *.json.gz or Content-Encoding: gzip")
+	configMaxRedirects := flag.Int("config-max-redirects", 10, "redirects followed fetching an HTTP config")
+	s3Endpoint := flag.String("s3-endpoint", os.Getenv("S3_ENDPOINT"), "endpoint of an S3 compatible store for s3:// configs")
+	s3Region := flag.String("s3-region", os.Getenv("S3_REGION"), "region for s3:// configs")
+	s3ForcePathStyle := flag.Bool("s3-force-path-style", os.Getenv("S3_FORCE_PATH_STYLE") == "true", "use path-style addressing for s3:// configs")
+	logFormat := flag.String("log-format", os.Getenv("LOG_FORMAT"), "log format, text (default) or json")
+	logLevel := flag.String("log-level", os.Getenv("LOG_LEVEL"), "minimum log level: debug, info (default), warn or error")
 	help := flag.Bool("help", false, "show help")
+	configSchema := flag.Bool("config-schema", false, "print the JSON schema of the config file and exit")
+	cleanupDangling := flag.Bool("cleanup-dangling", false, "remove local images after each successful sync")
+	exportMetricsFlag := flag.Bool("export-metrics", false, "print the sync state of each image as JSON and exit")
+	staleAfter := flag.Duration("stale-after", 0, "age after which an image is stale for -export-metrics (default twice the duration)")
+	once := flag.Bool("once", false, "run a single sync cycle and exit")
+	dockerContext := flag.String("docker-context", "", "Docker CLI context to connect to (overrides docker_context_name)")
+	maxCycleErrors := flag.Int("max-cycle-errors", 0, "exit after this many consecutive cycles with errors (overrides max_cycle_errors)")
+	requeueFailed := flag.Bool("requeue-failed", false, "sync failed images again at the end of each cycle, requeue_max_attempts times (default 3)")
+	rollingUpdate := flag.Bool("rolling-update", false, "sync images one at a time, pausing delay_between_images between them")
+	clearStateFlag := flag.Bool("clear-state", false, "reset the state file and exit, or sync from scratch with -once")
+	confirm := flag.Bool("confirm", false, "confirm destructive operations such as -clear-state")
+	group := flag.String("group", "", "only sync images whose group_by matches this value")
+	diffFlag := flag.Bool("diff", false, "compare source and target registries without syncing and exit")
+	validateTargets := flag.Bool("validate-targets", false, "check that every target registry accepts pushes and exit")
+	compareDigests := flag.Bool("compare-digests", false, "report matching, missing and mismatched target digests without syncing and exit")
+	estimateSize := flag.Bool("estimate-size", false, "print the bytes a sync would download and upload without syncing and exit")
+	k8sDiscover := flag.Bool("k8s-discover", false, "add the images of running Kubernetes pods to the sync list")
+	k8sNamespace := flag.String("k8s-namespace", "", "namespace for -k8s-discover (default all namespaces)")
+	k8sTargetRegistry := flag.String("k8s-target-registry", "", "registry prefix discovered images are mirrored to")
+	cleanupRegistry := flag.Bool("cleanup-registry", false, "delete target tags that no longer exist at the source and exit")
+	dryRun := flag.Bool("dry-run", false, "only log what -cleanup-registry would delete")
+	gitopsRepo := flag.String("gitops-repo", "", "local Git repository whose Flux ImagePolicy resources are added to the sync list")
+	gitopsTargetRegistry := flag.String("gitops-target-registry", "", "registry prefix images from -gitops-repo are mirrored to")
+	sinceLastSuccess := flag.Bool("since-last-success", false, "only sync images that failed or were never synced according to the state file")
+	k8sRediscover := flag.Bool("k8s-rediscover", false, "repeat Kubernetes discovery every cycle instead of only at startup")
+	loadTar := flag.String("load-tar", "", "load the images of an archive written to a tar:// target into the daemon and exit")
+	profile := flag.String("profile", "", "write a cpu or mem profile of a single cycle to the file given after the flags, e.g. -profile cpu out.prof")
+	summaryOnly := flag.Bool("summary-only", false, "only log errors, warnings and cycle summaries, not the progress of each image")
+	smokeTest := flag.Bool("smoke-test", false, "sync a tiny image from Docker Hub to smoke_test_target instead of the configured images and exit")
+	reportFile := flag.String("report-file", "", "write a JSON report of each cycle to this file")
+	ignoreErrors := flag.Bool("ignore-errors", false, "exit 0 even if images fail to sync, errors are still logged")
+	metricsReport := flag.Int("metrics-report", 0, "print the last N cycles recorded in sqlite_metrics_db and exit")
+	var images imageFlags
+	flag.Var(&images, "image", "sync source=<src>,target=<tgt> once without a config file (repeatable)")
+	var filters imageFilters
+	flag.Var(&filters, "image-filter", "only push pulled images with this label, label=<key>[=<value>] (repeatable)")
 	flag.Parse()
 
 	if *help {
@@ -26,42 +92,555 @@ func main() {
 		return
 	}
 
-	config, err := loadConfig(*cfg)
+	if len(images) > 0 || *profile != "" {
+		*once = true
+	}
+
+	warnings := new(atomic.Int64)
+	newSyncLogger := func(w io.Writer) *slog.Logger {
+		logger := countWarnings(newLogger(w, *logFormat, *logLevel), warnings)
+		if *summaryOnly {
+			logger = withoutImageInfo(logger)
+		}
+		return logger
+	}
+	logger := newSyncLogger(os.Stderr)
+
+	if *configSchema {
+		if e := writeConfigSchema(os.Stdout); e != nil {
+			fatal(logger, "Failed to write config schema", "error", e)
+		}
+		return
+	}
+
+	if *loadTar != "" {
+		cli, e := newDockerClient(&Config{DockerContextName: *dockerContext}, logger)
+		if e != nil {
+			fatal(logger, "Failed to create Docker client", "error", e)
+		}
+		defer cli.Close()
+		if e := loadArchive(context.Background(), cli, *loadTar); e != nil {
+			fatal(logger, "Failed to load archive", "error", e)
+		}
+		logger.Info("Loaded archive", "path", *loadTar)
+		return
+	}
+
+	opts := &loadOptions{
+		Accept:           *configAccept,
+		DecryptKey:       *decryptKey,
+		S3Endpoint:       *s3Endpoint,
+		S3Region:         *s3Region,
+		S3ForcePathStyle: *s3ForcePathStyle,
+		HTTPTimeout:      *configTimeout,
+		HTTPMaxRedirects: *configMaxRedirects,
+		Format:           *configFormatFlag,
+		GZipConfig:       *gzipConfig,
+		Logger:           logger,
+	}
+	if *convertConfigFlag {
+		if e := convertConfig(*cfg, *convertTo, opts, os.Stdout); e != nil {
+			fatal(logger, "Failed to convert config", "error", e)
+		}
+		return
+	}
+
+	var discovered []ImageConfig
+	load := func() (*Config, error) {
+		var c *Config
+		if len(images) > 0 {
+			c = &Config{Images: images, Auths: loadDefaultAuth(logger)}
+		} else {
+			var e error
+			if c, e = loadConfig(*cfg, opts); e != nil {
+				return nil, e
+			}
+		}
+		if *cleanupDangling {
+			c.CleanupAfterSync = true
+		}
+		if *dockerContext != "" {
+			c.DockerContextName = *dockerContext
+		}
+		if *maxCycleErrors > 0 {
+			c.MaxCycleErrors = *maxCycleErrors
+		}
+		if *requeueFailed && c.RequeueMaxAttempts == 0 {
+			c.RequeueMaxAttempts = defaultRequeueAttempts
+		}
+		if *rollingUpdate {
+			c.RollingUpdate = true
+		}
+		if *k8sDiscover {
+			if discovered == nil || *k8sRediscover {
+				found, e := discoverKubernetesImages(context.Background(), logger, *k8sNamespace, *k8sTargetRegistry)
+				if e != nil {
+					return nil, e
+				}
+				discovered = found
+			}
+			c.Images = append(c.Images, discovered...)
+		}
+		if *gitopsRepo != "" {
+			found, e := discoverGitOpsImages(logger, *gitopsRepo, *gitopsTargetRegistry)
+			if e != nil {
+				return nil, e
+			}
+			c.Images = append(c.Images, found...)
+		}
+		if *group != "" {
+			c.Images = filterImagesByGroup(c.Images, *group)
+		}
+		if e := checkRegistryCopies(c.Images, filters); e != nil {
+			return nil, e
+		}
+		return c, nil
+	}
+
+	config, err := load()
 	if err != nil {
-		log.Fatal(err)
+		fatal(logger, "Failed to load config", "error", err)
 	}
 
-	cli, err := client.NewClientWithOpts(
-		client.FromEnv,
-		client.WithAPIVersionNegotiation(),
-	)
+	if *smokeTest {
+		if config.SmokeTestTarget == "" {
+			fatal(logger, "-smoke-test requires smoke_test_target")
+		}
+		config.Images = []ImageConfig{{Source: smokeTestImage, Target: config.SmokeTestTarget}}
+		config.StateFile, config.CheckpointFile, config.Etcd = "", "", nil
+		*once = true
+	}
+
+	if *metricsReport > 0 {
+		if config.SQLiteMetricsDB == "" {
+			fatal(logger, "-metrics-report requires sqlite_metrics_db")
+		}
+		if e := printHistoryReport(config.SQLiteMetricsDB, *metricsReport, os.Stdout); e != nil {
+			fatal(logger, "Failed to print metrics report", "error", e)
+		}
+		return
+	}
+
+	if *exportMetricsFlag {
+		stale, e := exportMetrics(config, *staleAfter)
+		if e != nil {
+			fatal(logger, "Failed to export metrics", "error", e)
+		}
+		if stale {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *diffFlag {
+		s := &syncer{config: config, logger: logger, registry: newRegistryClient(config)}
+		if !s.printDiff(context.Background(), os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *validateTargets {
+		s := &syncer{config: config, logger: logger, registry: newRegistryClient(config)}
+		if !s.validateTargets(context.Background()) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *compareDigests {
+		s := &syncer{config: config, logger: logger, registry: newRegistryClient(config)}
+		if !s.printDigestReport(context.Background(), os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *estimateSize {
+		s := &syncer{config: config, logger: logger, registry: newRegistryClient(config)}
+		if !s.printSizeEstimate(context.Background(), os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *cleanupRegistry {
+		s := &syncer{config: config, logger: logger, audit: newAuditLogger(config.AuditLogFile, logger), registry: newRegistryClient(config)}
+		e := s.cleanupRegistry(context.Background(), *dryRun)
+		_ = s.audit.Close()
+		if e != nil {
+			fatal(logger, "Failed to clean up registry", "error", e)
+		}
+		return
+	}
+
+	if *clearStateFlag {
+		if !*confirm {
+			fatal(logger, "-clear-state requires -confirm")
+		}
+		if e := config.clearState(); e != nil {
+			fatal(logger, "Failed to clear state", "error", e)
+		}
+		logger.Info("Cleared state", "path", config.StateFile)
+		if !*once {
+			return
+		}
+	}
+
+	var progress *progressDisplay
+	var logs *logBuffer
+	if !strings.EqualFold(*logFormat, "json") && term.IsTerminal(int(os.Stdout.Fd())) {
+		progress = newProgressDisplay(os.Stdout)
+	}
+	if config.AdminListenAddr != "" && !*once {
+		logs = newLogBuffer(logBufferSize)
+	}
+	if progress != nil || logs != nil {
+		var w io.Writer = os.Stderr
+		if progress != nil {
+			w = progress
+		}
+		if logs != nil {
+			w = io.MultiWriter(w, logs)
+		}
+		logger = newSyncLogger(w)
+		opts.Logger = logger
+	}
+
+	cli, err := newDockerClient(config, logger)
 	if err != nil {
-		log.Fatalf("Failed to create Docker client: %v", err)
+		fatal(logger, "Failed to create Docker client", "error", err)
 	}
 	defer cli.Close()
+	var ctrd *containerd.Client
+	if config.Runtime == runtimeContainerd {
+		if ctrd, err = newContainerdClient(config); err != nil {
+			fatal(logger, "Failed to create containerd client", "error", err)
+		}
+	} else {
+		warnInsecureRegistries(context.Background(), cli, config.Auths, logger)
+	}
 
-	for {
-		if e := processImages(cli, config); e != nil {
-			log.Printf("Error processing images: %v", e)
+	pool, err := newClientPool(config, logger)
+	if err != nil {
+		fatal(logger, "Failed to create Docker client", "error", err)
+	}
+
+	var history *sql.DB
+	if config.SQLiteMetricsDB != "" {
+		if history, err = openHistory(config.SQLiteMetricsDB); err != nil {
+			fatal(logger, "Failed to open metrics database", "error", err)
+		}
+	}
+
+	var locker imageLocker
+	if config.DistributedLock != nil {
+		if locker, err = newImageLocker(config.DistributedLock); err != nil {
+			fatal(logger, "Failed to create distributed lock", "error", err)
+		}
+	}
+
+	s := &syncer{
+		cli:      cli,
+		pool:     pool,
+		config:   config,
+		logger:   logger,
+		audit:    newAuditLogger(config.AuditLogFile, logger),
+		registry: newRegistryClient(config),
+		limiter:  newPushLimiter(config.Auths, logger),
+		pulls:    newPullSemaphore(config.ConcurrencyPerRegistry),
+		events:   newEventBus(),
+		progress: progress,
+		warnings: warnings,
+		filters:  filters,
+
+		containerd: ctrd,
+		history:    history,
+		locker:     locker,
+	}
+	s.subscribeHandlers()
+	s.throttle()
+	if *reportFile != "" {
+		s.events.Subscribe(CycleCompleted, func(ev Event) {
+			if e := writeReport(*reportFile, ev.Start, ev.Results); e != nil {
+				logger.Error("Error writing report", "path", *reportFile, "error", e)
+			}
+		})
+	}
+	defer s.Close()
+
+	if *sinceLastSuccess {
+		if !config.hasState() {
+			fatal(logger, "-since-last-success requires state_file or etcd")
+		}
+		s.sinceLastSuccess = true
+	}
+
+	var events <-chan registryEvent
+	if config.WebhookListenAddr != "" && !*once {
+		token := os.ExpandEnv(config.WebhookToken)
+		if token == "" {
+			fatal(logger, "webhook_listen_addr requires webhook_token")
+		}
+		events = startWebhookServer(config.WebhookListenAddr, token, logger)
+	}
+	if logs != nil {
+		startAdminServer(config.AdminListenAddr, logs, logger)
+	}
+	if config.WarmUpSchedule != "" && !*once {
+		if s.warmUps, err = scheduleWarmUps(config.WarmUpSchedule); err != nil {
+			fatal(logger, "Invalid warm_up_schedule", "error", err)
+		}
+	}
+	var changes <-chan struct{}
+	if !*once {
+		changes = watchConfig(*cfg, parseDuration(logger, "watch_config_interval", config.WatchConfigInterval), opts, logger)
+	}
+
+	stopProfile := func() {}
+	if *profile != "" {
+		output := flag.Arg(0)
+		if output == "" {
+			output = *profile + ".prof"
+		}
+		if stopProfile, err = startProfile(*profile, output, logger); err != nil {
+			fatal(logger, "Failed to start profile", "error", err)
+		}
+	}
+
+	if config.ValidateTargetsOnStart && !*once && !s.validateTargets(context.Background()) {
+		fatal(logger, "Target validation failed")
+	}
+
+	if *ignoreErrors && config.StrictMode {
+		logger.Warn("-ignore-errors is incompatible with strict_mode, failing on errors")
+	}
+
+	failedCycles := 0
+	for cycle := 0; ; cycle++ {
+		if cycle > 0 && s.config.RefreshAuthEachCycle {
+			if newConfig, e := load(); e == nil {
+				s.updateConfig(newConfig)
+			} else {
+				logger.Error("Error reloading config", "error", e)
+			}
 		}
 
-		if !config.DisablePrune {
-			if e := pruneUnusedImages(cli); e != nil {
-				log.Printf("Error pruning unused images: %v", e)
+		err := s.runCycle()
+		if err != nil && *ignoreErrors && !s.config.StrictMode {
+			err = nil
+		}
+		if *once {
+			stopProfile()
+			if err != nil {
+				s.Close()
+				os.Exit(1)
 			}
+			return
 		}
 
-		if newConfig, e := loadConfig(*cfg); e == nil {
-			config = newConfig
+		if err == nil {
+			failedCycles = 0
+		} else if failedCycles++; s.config.MaxCycleErrors > 0 && failedCycles >= s.config.MaxCycleErrors {
+			s.Close()
+			fatal(logger, "Too many consecutive failed cycles, exiting", "cycles", failedCycles)
 		}
 
-		log.Printf("Sleeping for %d seconds", config.Duration)
-		time.Sleep(time.Duration(config.Duration) * time.Second)
+		// A watched config is only fetched again once it changed.
+		if !s.config.RefreshAuthEachCycle && changes == nil {
+			if newConfig, e := load(); e == nil {
+				s.updateConfig(newConfig)
+			}
+		}
+
+		logger.Info("Sleeping", "seconds", s.config.Duration)
+		if s.sleep(time.Duration(s.config.Duration)*time.Second, events, changes) && !s.config.RefreshAuthEachCycle {
+			if newConfig, e := load(); e == nil {
+				s.updateConfig(newConfig)
+			} else {
+				logger.Error("Error reloading config", "error", e)
+			}
+		}
 	}
 }
 
-func processImages(cli *client.Client, config *Config) error {
-	for _, img := range config.Images {
+type syncer struct {
+	cli      *client.Client
+	pool     *clientPool
+	config   *Config
+	logger   *slog.Logger
+	audit    *auditLogger
+	registry *registryClient
+	limiter  *pushLimiter
+	pulls    *pullSemaphore
+	events   *EventBus
+	results  []*imageResult
+	digests  *digestCache
+	progress *progressDisplay
+	filters  imageFilters
+	archives archiveQueue
+	sampler  errorSampler
+	warnings *atomic.Int64
+
+	// containerd replaces the Docker daemon for runtime "containerd".
+	containerd *containerd.Client
+
+	// history is the sqlite_metrics_db database, cycleStart the start of the
+	// current cycle.
+	history    *sql.DB
+	cycleStart time.Time
+
+	// locker is the distributed_lock taken per image, nil without one.
+	locker imageLocker
+
+	// warmUps receives the times of warm_up_schedule.
+	warmUps <-chan time.Time
+
+	// bandwidth enforces max_copy_bandwidth_mbps, nil without a limit.
+	bandwidth *bandwidthLimiter
+
+	// checkpoint is only set while runCycle syncs the configured images.
+	checkpoint *checkpoint
+
+	// diskCheckWarning warns once that the Docker data root cannot be checked.
+	diskCheckWarning sync.Once
+
+	sinceLastSuccess bool
+}
+
+func (s *syncer) Close() {
+	_ = s.audit.Close()
+	_ = s.pool.Close()
+	if s.containerd != nil {
+		_ = s.containerd.Close()
+	}
+	if s.history != nil {
+		_ = s.history.Close()
+	}
+	if s.locker != nil {
+		_ = s.locker.Close()
+	}
+}
+
+// runCycle syncs all images once and runs the per-cycle side effects,
+// returning the sync error if any image failed or errQuotaLow when the cycle
+// was skipped.
+func (s *syncer) runCycle() error {
+	if !s.checkFreeSpace(context.Background()) {
+		return errQuotaLow
+	}
+
+	warnings := s.warnings.Load()
+	s.cycleStart = time.Now()
+	ctx := context.Background()
+	if timeout := parseDuration(s.logger, "transfer_timeout", s.config.TransferTimeout); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if s.config.CheckpointFile != "" {
+		c, e := loadCheckpoint(s.config.CheckpointFile)
+		if e != nil {
+			s.logger.Error("Error loading checkpoint", "error", e)
+		} else if len(c.Completed) > 0 {
+			s.logger.Info("Resuming interrupted cycle", "started_at", c.StartedAt, "completed", len(c.Completed))
+		}
+		s.checkpoint = c
+	}
+	images := s.expandImages(ctx, s.config.Images)
+	if s.sinceLastSuccess {
+		images = s.pendingImages(images)
+	}
+	err := s.processImages(ctx, images)
+	err = s.requeueFailed(ctx, err)
+	if err != nil {
+		s.logger.Error("Error processing images", "error", err)
+	}
+
+	s.logSummary(s.cycleStart)
+	s.events.Publish(Event{Type: CycleCompleted, Start: s.cycleStart, Results: s.results})
+
+	if n := s.warnings.Load() - warnings; s.config.StrictMode && n > 0 {
+		e := fmt.Errorf("strict mode: %d warnings logged during the cycle", n)
+		s.logger.Error("Cycle failed in strict mode", "warnings", n)
+		err = errors.Join(err, e)
+	}
+
+	if e := s.recordState(s.results); e != nil {
+		s.logger.Error("Error saving state", "error", e)
+	}
+	if e := s.checkpoint.clear(); e != nil {
+		s.logger.Error("Error clearing checkpoint", "error", e)
+	}
+	s.checkpoint = nil
+
+	if !s.config.DisablePrune && s.containerd == nil {
+		if e := s.pruneUnusedImages(); e != nil {
+			s.logger.Error("Error pruning unused images", "error", e)
+		}
+	}
+
+	return err
+}
+
+func (s *syncer) updateConfig(config *Config) {
+	if config.AuditLogFile != s.config.AuditLogFile {
+		_ = s.audit.Close()
+		s.audit = newAuditLogger(config.AuditLogFile, s.logger)
+	}
+	if config.concurrency() != s.pool.size() {
+		if pool, e := newClientPool(config, s.logger); e == nil {
+			_ = s.pool.Close()
+			s.pool = pool
+		} else {
+			s.logger.Error("Failed to resize Docker client pool", "error", e)
+		}
+	}
+	s.config = config
+	s.registry = newRegistryClient(config)
+	s.limiter = newPushLimiter(config.Auths, s.logger)
+	s.pulls = newPullSemaphore(config.ConcurrencyPerRegistry)
+	s.throttle()
+}
+
+// processImages syncs images as the results of the current cycle.
+func (s *syncer) processImages(ctx context.Context, images []ImageConfig) error {
+	results, err := s.syncImages(ctx, images)
+	s.results = results
+	return err
+}
+
+// syncImages syncs images, whose glob sources must already be expanded, and
+// returns their results, one per image after filtering and ordering them.
+func (s *syncer) syncImages(ctx context.Context, images []ImageConfig) ([]*imageResult, error) {
+	config := s.config
+	images, sequential := orderImages(s.prioritizeImages(ctx, s.unexpiredImages(ctx, images)), config.SyncOrder)
+	if config.AutoOrderByBase {
+		rest, bases := s.orderByBase(ctx, images[sequential:])
+		images = append(images[:sequential:sequential], rest...)
+		sequential += bases
+	}
+	results := make([]*imageResult, len(images))
+	s.digests = newDigestCache()
+	s.progress.reset(images)
+
+	g := new(errgroup.Group)
+	g.SetLimit(s.pool.size())
+	delay := parseDuration(s.logger, "delay_between_images", config.DelayBetweenImages)
+	started := 0
+	policy := &failurePolicy{}
+	for i, img := range images {
+		configured := img
+		if _, archive := archivePath(img.Target); !archive {
+			target, err := resolveTarget(img.Source, img.Target, config.NamespaceMappings)
+			if err != nil {
+				results[i] = newImageResult(&img, &configured)
+				results[i].finish(err)
+				s.logger.Error("Error processing image", "image", img.Source, "error", err)
+				continue
+			}
+			img.Target = affixTag(target, img.TargetTagPrefix, img.TargetTagSuffix)
+		}
 		pull := image.PullOptions{
 			All: true,
 		}
@@ -69,93 +648,570 @@ func processImages(cli *client.Client, config *Config) error {
 			All: true,
 		}
 		if config.Auths != nil {
-			for registry, auth := range config.Auths {
-				if strings.HasPrefix(img.Source, registry) {
-					pull.RegistryAuth = auth.Auth
+			pull.RegistryAuth = lookupAuth(config.Auths, img.Source)
+			push.RegistryAuth = lookupAuth(config.Auths, img.Target)
+		}
+		result := newImageResult(&img, &configured)
+		results[i] = result
+		if e := imageAuths(&img, &pull, &push); e != nil {
+			result.finish(e)
+			s.logger.Error("Error processing image", "image", img.Source, "error", e)
+			continue
+		}
+		job := func() error {
+			if e := ctx.Err(); e != nil {
+				result.finish(e)
+				return nil
+			}
+			if s.checkpoint.contains(&img) {
+				s.logger.Info("image synced before the cycle was interrupted, skip", "image", img.Source)
+				result.finish(nil)
+				s.progress.setStatus(img.Source, progressDone)
+				return nil
+			}
+			if e := policy.blocked(&img); e != nil {
+				s.logger.Warn("skip image after an earlier failure", "image", img.Source, "reason", e)
+				result.finish(e)
+				s.progress.setStatus(img.Source, progressError)
+				return nil
+			}
+			unlock, locked, e := s.lockImage(ctx, img.Source)
+			if e != nil {
+				s.logger.Error("Error processing image", "image", img.Source, "error", e)
+				result.finish(e)
+				s.progress.setStatus(img.Source, progressError)
+				return nil
+			}
+			if !locked {
+				s.logger.Info("image is locked by another instance, skip", "image", img.Source)
+				result.Status = statusSkipped
+				result.finish(nil)
+				s.progress.setStatus(img.Source, progressSkipped)
+				return nil
+			}
+			defer unlock()
+			cli := s.pool.get()
+			defer s.pool.put(cli)
+			result.StartedAt = time.Now()
+			maxRetryDuration := parseDuration(s.logger, "max_retry_duration", config.MaxRetryDuration)
+			if img.MaxRetryDuration != "" {
+				maxRetryDuration = parseDuration(s.logger, "max_retry_duration", img.MaxRetryDuration)
+			}
+			e = retryWithBackoff(ctx, s.logger, img.Source, config.MaxRetries, maxRetryDuration, func() error {
+				return s.processImage(ctx, cli, &img, &pull, &push, result)
+			})
+			result.finish(e)
+			switch {
+			case e != nil:
+				s.events.Publish(Event{Type: ImageSyncFailed, Image: img.Source, Start: result.StartedAt, Err: e, Result: result})
+				policy.fail(&img)
+				s.progress.setStatus(img.Source, progressError)
+				if ok, suppressed := s.sampler.sample(img.Source, e, config.ErrorSampleRate); ok && suppressed > 0 {
+					s.logger.Error("Error processing image", "image", img.Source, "error", e, "suppressed", suppressed)
+				} else if ok {
+					s.logger.Error("Error processing image", "image", img.Source, "error", e)
 				}
-				if strings.HasPrefix(img.Target, registry) {
-					push.RegistryAuth = auth.Auth
+			case result.Status == statusSkipped:
+				s.progress.setStatus(img.Source, progressSkipped)
+				s.sampler.reset(img.Source)
+			default:
+				s.progress.setStatus(img.Source, progressDone)
+				s.sampler.reset(img.Source)
+			}
+			if e == nil {
+				if ce := s.checkpoint.add(&img); ce != nil {
+					s.logger.Error("Error saving checkpoint", "error", ce)
 				}
 			}
+			return nil
+		}
+		if i < sequential {
+			_ = job()
+		} else if config.RollingUpdate {
+			if started > 0 && delay > 0 && ctx.Err() == nil {
+				s.logger.Info("Waiting before next image", "delay", delay, "image", img.Source)
+				time.Sleep(delay)
+			}
+			_ = job()
+		} else {
+			g.Go(job)
+		}
+		started++
+	}
+	_ = g.Wait()
+
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
 		}
-		if err := processImage(cli, &img, &pull, &push); err != nil {
+	}
+	if len(errs) > 0 {
+		s.logger.Error("Some images failed", "failed", len(errs), "total", len(results), "summary", summarizeFailures(results))
+	}
+	if e := s.saveArchives(ctx); e != nil {
+		s.logger.Error("Error saving archives", "error", e)
+		errs = append(errs, e)
+	}
+	if e := ctx.Err(); e != nil {
+		s.logTimeout(results)
+		errs = append(errs, fmt.Errorf("sync cycle aborted: %w", e))
+	}
+	return results, errors.Join(errs...)
+}
+
+// imageAuths applies the PullAuthEnv and PushAuthEnv overrides of img.
+func imageAuths(img *ImageConfig, pull *image.PullOptions, push *image.PushOptions) error {
+	if img.PullAuthEnv != "" {
+		auth, err := envAuth(img.PullAuthEnv)
+		if err != nil {
+			return err
+		}
+		pull.RegistryAuth = auth
+	}
+	if img.PushAuthEnv != "" {
+		auth, err := envAuth(img.PushAuthEnv)
+		if err != nil {
 			return err
 		}
+		push.RegistryAuth = auth
 	}
 	return nil
 }
 
+// repoDigest returns the manifest digest from the first "name@digest" entry.
+func repoDigest(repoDigests []string) string {
+	for _, d := range repoDigests {
+		if _, digest, ok := strings.Cut(d, "@"); ok {
+			return digest
+		}
+	}
+	return ""
+}
+
 func readAllToDiscard(r io.ReadCloser) error {
 	defer r.Close()
 	_, e := io.Copy(io.Discard, r)
 	return e
 }
 
-func processImage(cli *client.Client, img *ImageConfig, pull *image.PullOptions, push *image.PushOptions) error {
-	log.Printf("start to process image %s", img.Source)
+func (s *syncer) processImage(ctx context.Context, cli *client.Client, img *ImageConfig, pull *image.PullOptions, push *image.PushOptions, result *imageResult) error {
+	s.logger.Info("start to process image", "image", img.Source)
+	audit := s.audit
+
+	if open, next, e := syncWindow(time.Now().In(s.config.location()), img.NotBefore, img.NotAfter); e != nil {
+		return e
+	} else if !open {
+		s.logger.Info("image is outside its sync window", "image", img.Source, "next", next.Format(time.RFC3339))
+		result.Status = statusSkipped
+		return nil
+	}
+
+	if ok, e := runPreSyncHook(s.logger, img); e != nil {
+		return e
+	} else if !ok {
+		result.Status = statusSkipped
+		return nil
+	}
+
+	if exists, e := s.immutableTagExists(ctx, img); e != nil {
+		return e
+	} else if exists {
+		s.logger.Warn("target is an immutable tag that already exists, skip push", "target", img.Target)
+		result.Status = statusSkipped
+		return nil
+	}
+
+	if e := s.checkManifestSchema(ctx, img); e != nil {
+		return e
+	}
+
+	if exceeds, size, e := s.exceedsMaxPullSize(ctx, img); e != nil {
+		return e
+	} else if exceeds {
+		s.logger.Warn("image exceeds the maximum pull size, skipping", "image", img.Source, "bytes", size, "max_pull_size", s.config.maxPullSize(img))
+		result.Status = statusSkipped
+		return nil
+	}
+
+	if img.PullMode == pullModeLazy && s.containerd == nil {
+		return fmt.Errorf("pull_mode lazy of %s requires runtime containerd", img.Source)
+	}
+	if s.containerd != nil {
+		return s.containerdSync(ctx, img, result)
+	}
+
+	if img.DeltaSync || img.NormalizeManifest {
+		start := time.Now()
+		release, e := s.pulls.acquire(ctx, img.Source)
+		if e != nil {
+			return e
+		}
+		s.progress.setStatus(img.Source, progressPushing)
+		copied, pushed, e := s.deltaSync(ctx, img)
+		release()
+		if e == nil && img.VerifyPushDigest {
+			e = s.verifyPushDigest(ctx, img, pushed)
+		}
+		s.events.Publish(Event{Type: ImagePushCompleted, Image: img.Target, Start: start, Err: e, Result: result})
+		if e != nil {
+			return &PushError{Image: img.Target, Step: stepPush, Cause: fmt.Errorf("delta sync from %s: %w", img.Source, e)}
+		}
+		result.PushDuration = time.Since(start)
+		result.Bytes = copied
+		result.Digest = pushed
+		s.logger.Info("delta sync success", "source", img.Source, "target", img.Target, "bytes", copied)
+		s.finishTarget(ctx, img)
+		return nil
+	}
 
 	// Pull image
-	reader, e := cli.ImagePull(context.Background(), img.Source, *pull)
-	if e != nil {
-		return fmt.Errorf("pull image %s failed: %w", img.Source, e)
+	start := time.Now()
+	digest := s.sourceDigest(ctx, img.Source)
+	if (img.PullPolicy == pullPolicyIfNotPresent || img.WarmUp) && s.imagePresent(ctx, cli, img.Source) {
+		s.logger.Info("image already present, skip pull", "image", img.Source)
+	} else if s.retagPulled(ctx, cli, img.Source, digest) {
+		s.logger.Info("image has the digest of an image pulled in this cycle, skip pull", "image", img.Source, "digest", digest)
+	} else if s.lowOnDisk(ctx, cli, img) {
+		result.Status = statusSkipped
+		return nil
+	} else {
+		release, e := s.pulls.acquire(ctx, img.Source)
+		if e != nil {
+			return e
+		}
+		s.progress.setStatus(img.Source, progressPulling)
+		s.events.Publish(Event{Type: ImagePullStarted, Image: img.Source, Start: start, Result: result})
+		mirror, e := s.pullSource(ctx, cli, img, pull)
+		release()
+		s.events.Publish(Event{Type: ImagePullCompleted, Image: img.Source, Start: start, Err: e, Result: result})
+		if e != nil {
+			return e
+		}
+		result.Mirror = mirror
+		s.digests.put(digest, img.Source)
+		result.PullDuration = time.Since(start)
+		s.logger.Info("pull image success", "image", img.Source)
 	}
-	if re := readAllToDiscard(reader); re != nil {
-		return fmt.Errorf("error while pulling image %s: %w", img.Source, re)
+	inspect, _, e := cli.ImageInspectWithRaw(ctx, img.Source)
+	if e == nil {
+		result.Bytes = inspect.Size
+		result.Digest = repoDigest(inspect.RepoDigests)
+	}
+	if len(s.filters) > 0 {
+		if e != nil {
+			return fmt.Errorf("inspect image %s failed: %w", img.Source, e)
+		}
+		var labels map[string]string
+		if inspect.Config != nil {
+			labels = inspect.Config.Labels
+		}
+		if !s.filters.match(labels) {
+			s.logger.Info("image labels do not match the image filter, skip push", "image", img.Source)
+			result.Status = statusSkipped
+			return nil
+		}
+	}
+
+	if e := s.checkSizeIncrease(ctx, img, result); e != nil {
+		return e
+	}
+
+	if path, ok := archivePath(img.Target); ok {
+		s.archives.add(path, img.Source)
+		return nil
 	}
-	log.Printf("pull image %s success", img.Source)
 
 	// Tag image
-	if e = cli.ImageTag(context.Background(), img.Source, img.Target); e != nil {
-		return fmt.Errorf("tag image %s to %s failed: %w", img.Source, img.Target, e)
+	start = time.Now()
+	s.progress.setStatus(img.Source, progressTagging)
+	if e := cli.ImageTag(ctx, img.Source, img.Target); e != nil {
+		e = &TagError{Image: img.Source, Target: img.Target, Step: stepTag, Cause: e}
+		audit.record("tag", img.Target, start, e)
+		return e
+	}
+	audit.record("tag", img.Target, start, nil)
+	s.logger.Info("tag image success", "source", img.Source, "target", img.Target)
+
+	if img.Transform != "" {
+		start = time.Now()
+		e := runTransform(s.logger, img)
+		audit.record("transform", img.Target, start, e)
+		if e != nil {
+			return e
+		}
 	}
-	log.Printf("tag image %s to %s success", img.Source, img.Target)
 
 	// Push image
-	reader, e = cli.ImagePush(context.Background(), img.Target, *push)
+	start = time.Now()
+	s.progress.setStatus(img.Source, progressPushing)
+	e = s.pushImageVerified(ctx, cli, img, push)
+	s.events.Publish(Event{Type: ImagePushCompleted, Image: img.Target, Start: start, Err: e, Result: result})
 	if e != nil {
-		return fmt.Errorf("push image %s failed: %w", img.Target, e)
+		return e
+	}
+	result.PushDuration = time.Since(start)
+	s.logger.Info("push image success", "image", img.Target)
+
+	s.finishTarget(ctx, img)
+
+	if s.config.CleanupAfterSync {
+		s.removeLocalImages(img.Source, img.Target)
 	}
-	if re := readAllToDiscard(reader); re != nil {
-		return fmt.Errorf("error while pushing image %s: %w", img.Target, re)
+
+	return nil
+}
+
+// finishTarget runs the per-image steps on the pushed target: copying
+// referrers, annotating it and tagging it with its digest.
+func (s *syncer) finishTarget(ctx context.Context, img *ImageConfig) {
+	// Referrers are copied before the annotations are written, so a re-put
+	// that would leave them on the old digest is detected and skipped.
+	if img.SyncReferrers {
+		if e := s.syncReferrers(ctx, img, img.ReferrerTypes); e != nil {
+			s.logger.Error("sync referrers failed", "image", img.Source, "error", e)
+		}
+	} else if img.SyncAttestations {
+		if e := s.syncReferrers(ctx, img, attestationArtifactTypes); e != nil {
+			s.logger.Error("sync attestations failed", "image", img.Source, "error", e)
+		}
+	}
+
+	if img.CopyAnnotations {
+		if e := s.copyAnnotations(ctx, img); e != nil {
+			s.logger.Error("copy annotations failed", "image", img.Target, "error", e)
+		}
+	}
+
+	if img.AnnotateWithSyncMetadata {
+		if e := s.annotateSyncMetadata(ctx, img); e != nil {
+			s.logger.Error("annotate sync metadata failed", "image", img.Target, "error", e)
+		}
+	}
+
+	if img.TagWithDigest {
+		if e := s.tagWithDigest(ctx, img); e != nil {
+			s.logger.Error("tag image with digest failed", "image", img.Target, "error", e)
+		}
 	}
-	log.Printf("push image %s success", img.Target)
+}
 
+// removeLocalImages drops the given references from the local daemon once they have been pushed.
+func (s *syncer) removeLocalImages(refs ...string) {
+	for _, ref := range refs {
+		start := time.Now()
+		_, e := s.cli.ImageRemove(context.Background(), ref, image.RemoveOptions{PruneChildren: true})
+		s.audit.record("delete", ref, start, e)
+		if e != nil {
+			s.logger.Error("Failed to remove local image", "image", ref, "error", e)
+			continue
+		}
+		s.logger.Info("Removed local image", "image", ref)
+	}
+}
+
+// pullImage pulls ref, passing each progress message to onProgress when set.
+func pullImage(ctx context.Context, cli *client.Client, ref string, pull *image.PullOptions, onProgress func(jsonmessage.JSONMessage)) error {
+	reader, e := cli.ImagePull(ctx, ref, *pull)
+	if e != nil {
+		return &PullError{Image: ref, Step: stepPull, Cause: e}
+	}
+	defer reader.Close()
+	if re := readJSONMessages(reader, onProgress); re != nil {
+		return &PullError{Image: ref, Step: stepPull, Cause: re}
+	}
 	return nil
 }
 
-func pruneUnusedImages(cli *client.Client) error {
-	log.Println("Pruning unused and untagged images")
+// pushImage pushes ref and returns the manifest digest the daemon reported for its tag.
+func pushImage(ctx context.Context, cli *client.Client, ref string, push *image.PushOptions, onProgress func(jsonmessage.JSONMessage)) (string, error) {
+	reader, e := cli.ImagePush(ctx, ref, *push)
+	if e != nil {
+		return "", &PushError{Image: ref, Step: stepPush, Cause: e}
+	}
+	defer reader.Close()
+	_, tag := splitTag(ref)
+	var digest string
+	re := readJSONMessages(reader, func(msg jsonmessage.JSONMessage) {
+		var aux struct {
+			Tag    string
+			Digest string
+		}
+		if msg.Aux != nil && json.Unmarshal(*msg.Aux, &aux) == nil && (aux.Tag == tag || digest == "") {
+			digest = aux.Digest
+		}
+		if onProgress != nil {
+			onProgress(msg)
+		}
+	})
+	if re != nil {
+		return "", &PushError{Image: ref, Step: stepPush, Cause: re}
+	}
+	return digest, nil
+}
+
+// readJSONMessages decodes a daemon pull or push stream, calling fn for each
+// message, and returns the first error the daemon reported in it.
+func readJSONMessages(r io.Reader, fn func(jsonmessage.JSONMessage)) error {
+	dec := json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		if e := dec.Decode(&msg); e == io.EOF {
+			return nil
+		} else if e != nil {
+			return e
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		// Podman may report stream errors without an errorDetail.
+		if msg.ErrorMessage != "" {
+			return errors.New(msg.ErrorMessage)
+		}
+		if fn != nil {
+			fn(msg)
+		}
+	}
+}
+
+// pushImageVerified pushes the target image. With VerifyPushDigest set it
+// checks that the registry serves the digest the daemon pushed, retrying the
+// push when a partial write left a different manifest behind.
+func (s *syncer) pushImageVerified(ctx context.Context, cli *client.Client, img *ImageConfig, push *image.PushOptions) error {
+	for attempt := 1; ; attempt++ {
+		if err := s.limiter.wait(ctx, img.Target); err != nil {
+			return err
+		}
+		digest, err := pushImage(ctx, cli, img.Target, push, s.progress.update(img.Source))
+		if err != nil || !img.VerifyPushDigest {
+			return err
+		}
+		ref, err := parseImageRef(img.Target)
+		if err != nil {
+			return err
+		}
+		info, found, err := s.registry.headManifest(ctx, ref)
+		if err != nil {
+			return &PushError{Image: img.Target, Step: stepPush, Cause: fmt.Errorf("verify digest: %w", err)}
+		}
+		mismatch := digestMismatch(info, found, digest)
+		if mismatch == nil {
+			return nil
+		}
+		if attempt >= verifyPushAttempts || s.config.StrictMode {
+			return &PushError{Image: img.Target, Step: stepPush, Cause: mismatch}
+		}
+		s.logger.Warn("push not verified, retrying", "image", img.Target, "error", mismatch)
+	}
+}
+
+// verifyPushDigest checks that the target manifest has the digest that was
+// pushed to it.
+func (s *syncer) verifyPushDigest(ctx context.Context, img *ImageConfig, digest string) error {
+	ref, err := parseImageRef(img.Target)
+	if err != nil {
+		return err
+	}
+	info, found, err := s.registry.headManifest(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("verify digest: %w", err)
+	}
+	return digestMismatch(info, found, digest)
+}
+
+// digestMismatch describes how the target manifest info differs from the
+// pushed digest, nil when it matches or no digest is known.
+func digestMismatch(info *manifestInfo, found bool, digest string) error {
+	switch {
+	case found && (digest == "" || info.Digest == digest):
+		return nil
+	case found:
+		return fmt.Errorf("registry has digest %s but %s was pushed", info.Digest, digest)
+	default:
+		return fmt.Errorf("target manifest not found after pushing %s", digest)
+	}
+}
+
+func (s *syncer) pruneUnusedImages() error {
+	if s.config.KeepUntagged {
+		s.logger.Info("Keeping untagged images, skip pruning")
+		return nil
+	}
+	s.logger.Info("Pruning unused and untagged images")
+	cli, audit := s.cli, s.audit
+	pruneStart := time.Now()
 
 	images, err := cli.ImageList(context.Background(), image.ListOptions{
 		All: true,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to list images: %w", err)
+		err = fmt.Errorf("failed to list images: %w", err)
+		audit.record("prune", "", pruneStart, err)
+		return err
 	}
 
 	var spaceReclaimed int64
 	var deletedCount int
 
+	parents := s.parentImages(images)
 	for _, img := range images {
-		if len(img.RepoTags) > 0 {
+		if len(img.RepoTags) > 0 || parents[img.ID] || s.pruneProtected(img) {
 			continue
 		}
 		if len(img.RepoTags) == 0 || (len(img.RepoTags) == 1 && strings.HasSuffix(img.RepoTags[0], ":<none>")) {
+			start := time.Now()
 			_, e := cli.ImageRemove(context.Background(), img.ID, image.RemoveOptions{Force: true, PruneChildren: true})
+			audit.record("delete", img.ID, start, e)
 			if e != nil {
 				imageName := "<unnamed>"
 				if len(img.RepoTags) > 0 {
 					imageName = img.RepoTags[0]
 				}
-				log.Printf("Failed to remove image %s (ID: %s): %v", imageName, img.ID, e)
+				s.logger.Error("Failed to remove image", "image", imageName, "id", img.ID, "error", e)
 				continue
 			}
 			spaceReclaimed += img.Size
 			deletedCount++
-			log.Printf("Removed image: %s", img.ID)
+			s.logger.Info("Removed image", "id", img.ID)
 		}
 	}
 
-	log.Printf("Pruned %d images, reclaimed space: %d bytes", deletedCount, spaceReclaimed)
+	s.logger.Info("Pruned images", "count", deletedCount, "reclaimed_bytes", spaceReclaimed)
+	audit.record("prune", "", pruneStart, nil)
 	return nil
 }
+
+// pruneProtected reports whether a tag or digest of img matches a
+// PruneProtect pattern.
+func (s *syncer) pruneProtected(img image.Summary) bool {
+	for _, pattern := range s.config.PruneProtect {
+		for _, ref := range slices.Concat(img.RepoTags, img.RepoDigests) {
+			if ok, _ := path.Match(pattern, ref); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parentImages returns the IDs of the images in the layer history of the
+// tagged images, which must survive pruning.
+func (s *syncer) parentImages(images []image.Summary) map[string]bool {
+	parents := make(map[string]bool)
+	for _, img := range images {
+		if len(img.RepoTags) == 0 {
+			continue
+		}
+		history, e := s.cli.ImageHistory(context.Background(), img.ID)
+		if e != nil {
+			s.logger.Warn("Failed to read image history", "image", img.RepoTags[0], "error", e)
+			continue
+		}
+		for _, h := range history {
+			if h.ID != img.ID && h.ID != "<missing>" {
+				parents[h.ID] = true
+			}
+		}
+	}
+	return parents
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+const sourcePlaceholder = "{{source}}"
+
+type NamespaceMapping struct {
+	SourcePrefix string `json:"source_prefix"`
+	TargetPrefix string `json:"target_prefix"`
+}
+
+// resolveTarget expands the {{source}} placeholder in target by rewriting the
+// source reference with the most specific matching namespace mapping.
+func resolveTarget(source, target string, mappings []NamespaceMapping) (string, error) {
+	if !strings.Contains(target, sourcePlaceholder) {
+		return target, nil
+	}
+	candidates := []string{source}
+	if named, e := reference.ParseNormalizedNamed(source); e == nil && named.String() != source {
+		candidates = append(candidates, named.String())
+	}
+	var best *NamespaceMapping
+	var matched string
+	for _, ref := range candidates {
+		for i := range mappings {
+			m := &mappings[i]
+			if !strings.HasPrefix(ref, m.SourcePrefix) {
+				continue
+			}
+			if best == nil || len(m.SourcePrefix) > len(best.SourcePrefix) {
+				best, matched = m, ref
+			}
+		}
+	}
+	if best == nil {
+		return "", fmt.Errorf("no namespace mapping matches source %s", source)
+	}
+	mapped := best.TargetPrefix + strings.TrimPrefix(matched, best.SourcePrefix)
+	return strings.ReplaceAll(target, sourcePlaceholder, mapped), nil
+}
+
+// affixTag adds prefix and suffix to the tag of target, "latest" when it has none.
+func affixTag(target, prefix, suffix string) string {
+	if prefix == "" && suffix == "" {
+		return target
+	}
+	repo, tag := splitTag(target)
+	if tag == "" {
+		tag = "latest"
+	}
+	return repo + ":" + prefix + tag + suffix
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+)
+
+// mirrorRef rewrites a Docker Hub reference to pull it through the mirror,
+// e.g. nginx:1.25 becomes <mirror>/library/nginx:1.25.
+func mirrorRef(source, mirror string) (string, bool) {
+	if mirror == "" {
+		return "", false
+	}
+	ref, err := parseImageRef(source)
+	if err != nil || ref.Domain != "docker.io" {
+		return "", false
+	}
+	return withRegistry(ref, mirror).String(), true
+}
+
+// withRegistry returns ref on another registry, given as a host or URL.
+func withRegistry(ref *imageRef, registry string) *imageRef {
+	mirrored := *ref
+	mirrored.Domain = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(registry, "https://"), "http://"), "/")
+	mirrored.Host = mirrored.Domain
+	mirrored.Raw = mirrored.String()
+	return &mirrored
+}
+
+// pullSource pulls the source image, through a source mirror or the registry
+// mirror when one applies, and returns the source mirror used if any. A
+// mirrored pull is tagged back to the original source reference so the
+// remaining steps are unaware of the mirror.
+func (s *syncer) pullSource(ctx context.Context, cli *client.Client, img *ImageConfig, pull *image.PullOptions) (string, error) {
+	if len(img.SourceMirrors) > 0 {
+		if mirror, ok := s.pullFromMirrors(ctx, cli, img, pull); ok {
+			return mirror, nil
+		}
+	}
+	mirrored, ok := mirrorRef(img.Source, s.config.RegistryMirror)
+	if !ok {
+		return "", pullImage(ctx, cli, img.Source, pull, s.progress.update(img.Source))
+	}
+	return "", s.pullMirrored(ctx, cli, img, mirrored, pull)
+}
+
+// pullFromMirrors pulls the source image from the first healthy source mirror
+// that serves it and returns that mirror.
+func (s *syncer) pullFromMirrors(ctx context.Context, cli *client.Client, img *ImageConfig, pull *image.PullOptions) (string, bool) {
+	ref, err := parseImageRef(img.Source)
+	if err != nil {
+		return "", false
+	}
+	for _, mirror := range s.sourceMirrors(img) {
+		mirrored := withRegistry(ref, mirror)
+		if !s.registry.ping(ctx, mirrored) {
+			s.logger.Warn("source mirror is unhealthy, skipping", "image", img.Source, "mirror", mirror)
+			continue
+		}
+		if e := s.pullMirrored(ctx, cli, img, mirrored.Raw, pull); e != nil {
+			s.logger.Warn("pull from source mirror failed", "image", img.Source, "mirror", mirror, "error", e)
+			continue
+		}
+		return mirror, true
+	}
+	s.logger.Warn("no source mirror could be pulled from, using the source registry", "image", img.Source)
+	return "", false
+}
+
+// sourceMirrors returns the source mirrors of img, moving the mirror the
+// state file recorded for its last successful pull to the front.
+func (s *syncer) sourceMirrors(img *ImageConfig) []string {
+	if !s.config.hasState() {
+		return img.SourceMirrors
+	}
+	state, err := s.config.loadState()
+	if err != nil {
+		return img.SourceMirrors
+	}
+	entry, ok := state.Images[img.Source]
+	if !ok || !slices.Contains(img.SourceMirrors, entry.Mirror) {
+		return img.SourceMirrors
+	}
+	mirrors := []string{entry.Mirror}
+	for _, m := range img.SourceMirrors {
+		if m != entry.Mirror {
+			mirrors = append(mirrors, m)
+		}
+	}
+	return mirrors
+}
+
+func (s *syncer) pullMirrored(ctx context.Context, cli *client.Client, img *ImageConfig, mirrored string, pull *image.PullOptions) error {
+	opts := *pull
+	opts.RegistryAuth = lookupAuth(s.config.Auths, mirrored)
+	if e := pullImage(ctx, cli, mirrored, &opts, s.progress.update(img.Source)); e != nil {
+		return e
+	}
+	s.logger.Info("pulled through mirror", "image", img.Source, "mirror", mirrored)
+	if e := cli.ImageTag(ctx, mirrored, img.Source); e != nil {
+		return &TagError{Image: mirrored, Target: img.Source, Step: stepTag, Cause: e}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ociMediaTypes maps Docker media types to their OCI equivalents. Blobs are
+// byte-identical between the two, only the descriptors change.
+var ociMediaTypes = map[string]string{
+	mediaTypeDockerManifest:                                     ocispec.MediaTypeImageManifest,
+	mediaTypeDockerManifestList:                                 ocispec.MediaTypeImageIndex,
+	"application/vnd.docker.container.image.v1+json":            ocispec.MediaTypeImageConfig,
+	"application/vnd.docker.image.rootfs.diff.tar.gzip":         ocispec.MediaTypeImageLayerGzip,
+	"application/vnd.docker.image.rootfs.foreign.diff.tar.gzip": "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip",
+}
+
+// normalizeManifest rewrites the Docker media types of a manifest or index
+// to OCI ones. Index entries found in children are replaced by the
+// descriptor of their normalized manifest. It returns the descriptor and body
+// of the normalized manifest.
+func normalizeManifest(body []byte, children map[string]ocispec.Descriptor) (ocispec.Descriptor, []byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var m map[string]any
+	if err := dec.Decode(&m); err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	convert := func(d map[string]any) {
+		if mt, ok := d["mediaType"].(string); ok {
+			if oci, ok := ociMediaTypes[mt]; ok {
+				d["mediaType"] = oci
+			}
+		}
+	}
+	convert(m)
+	if config, ok := m["config"].(map[string]any); ok {
+		convert(config)
+	}
+	if layers, ok := m["layers"].([]any); ok {
+		for _, l := range layers {
+			if layer, ok := l.(map[string]any); ok {
+				convert(layer)
+			}
+		}
+	}
+	if manifests, ok := m["manifests"].([]any); ok {
+		for _, e := range manifests {
+			entry, ok := e.(map[string]any)
+			if !ok {
+				continue
+			}
+			old, _ := entry["digest"].(string)
+			if child, ok := children[old]; ok {
+				entry["mediaType"] = child.MediaType
+				entry["digest"] = child.Digest.String()
+				entry["size"] = child.Size
+			} else {
+				convert(entry)
+			}
+		}
+	}
+	out, err := json.Marshal(m)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	mediaType, _ := m["mediaType"].(string)
+	return ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(out), Size: int64(len(out))}, out, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	notifyFailure  = "failure"
+	notifyRecovery = "recovery"
+)
+
+// NotifyConfig sends a webhook request for every failed image and, with
+// NotifyOnSuccess, for images succeeding again after failing in the previous
+// cycle, which is read from the state file.
+type NotifyConfig struct {
+	Webhook         ImageWebhook `json:"webhook"`
+	NotifyOnSuccess bool         `json:"notify_on_success"`
+}
+
+type notification struct {
+	Event     string    `json:"event"`
+	Source    string    `json:"source"`
+	Target    string    `json:"target"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// notifyCycle sends the notifications for the results of a cycle before
+// they are recorded in the state.
+func (s *syncer) notifyCycle() {
+	if s.config.Notify == nil {
+		return
+	}
+	state := &SyncState{Images: make(map[string]*ImageState)}
+	if s.config.hasState() {
+		var err error
+		if state, err = s.config.loadState(); err != nil {
+			s.logger.Error("Error loading state for notifications", "error", err)
+			return
+		}
+	}
+	s.notifyResults(state)
+}
+
+// notifyResults sends the notifications for the results of a cycle, using
+// the state recorded before it to detect recoveries.
+func (s *syncer) notifyResults(state *SyncState) {
+	config := s.config.Notify
+	if config == nil || config.Webhook.URL == "" {
+		return
+	}
+	for _, r := range s.results {
+		n := notification{Source: r.Source, Target: r.Target, Timestamp: r.FinishedAt}
+		switch r.Status {
+		case statusFailed:
+			n.Event = notifyFailure
+			n.Error = r.Err.Error()
+		case statusSuccess:
+			prev, ok := state.Images[r.Source]
+			if !config.NotifyOnSuccess || !ok || prev.LastStatus != statusFailed {
+				continue
+			}
+			n.Event = notifyRecovery
+		default:
+			continue
+		}
+		if e := sendNotification(context.Background(), config.Webhook, n); e != nil {
+			s.logger.Error("Failed to send notification", "event", n.Event, "image", r.Source, "error", e)
+		}
+	}
+}
+
+func sendNotification(ctx context.Context, webhook ImageWebhook, n notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	method := webhook.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
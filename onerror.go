@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	onErrorContinue     = "continue"
+	onErrorStop         = "stop"
+	onErrorSkipRegistry = "skip-registry"
+)
+
+// failurePolicy applies the on_error policy of failed images to the images
+// not yet started in the same processImages call.
+type failurePolicy struct {
+	mu         sync.Mutex
+	stoppedBy  string
+	registries map[string]string
+}
+
+func sourceRegistry(source string) string {
+	ref, err := parseImageRef(source)
+	if err != nil {
+		return ""
+	}
+	return ref.Domain
+}
+
+// fail records the failure of img.
+func (p *failurePolicy) fail(img *ImageConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch img.OnError {
+	case onErrorStop:
+		if p.stoppedBy == "" {
+			p.stoppedBy = img.Source
+		}
+	case onErrorSkipRegistry:
+		if p.registries == nil {
+			p.registries = make(map[string]string)
+		}
+		if registry := sourceRegistry(img.Source); registry != "" && p.registries[registry] == "" {
+			p.registries[registry] = img.Source
+		}
+	}
+}
+
+// blocked returns why img must not be synced after an earlier failure.
+func (p *failurePolicy) blocked(img *ImageConfig) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stoppedBy != "" {
+		return fmt.Errorf("cycle stopped after %s failed", p.stoppedBy)
+	}
+	if failed := p.registries[sourceRegistry(img.Source)]; failed != "" {
+		return fmt.Errorf("registry skipped after %s failed", failed)
+	}
+	return nil
+}
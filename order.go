@@ -0,0 +1,31 @@
+package main
+
+// orderImages moves the images listed in order to the front, in that order,
+// and returns how many were moved. Sources listed more than once or not
+// configured are ignored.
+func orderImages(images []ImageConfig, order []string) ([]ImageConfig, int) {
+	if len(order) == 0 {
+		return images, 0
+	}
+	rank := make(map[string]int, len(order))
+	for i, source := range order {
+		if _, ok := rank[source]; !ok {
+			rank[source] = i
+		}
+	}
+	first := make([][]ImageConfig, len(order))
+	var rest []ImageConfig
+	for _, img := range images {
+		if i, ok := rank[img.Source]; ok {
+			first[i] = append(first[i], img)
+		} else {
+			rest = append(rest, img)
+		}
+	}
+	ordered := make([]ImageConfig, 0, len(images))
+	for _, imgs := range first {
+		ordered = append(ordered, imgs...)
+	}
+	n := len(ordered)
+	return append(ordered, rest...), n
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+const (
+	pullPolicyAlways       = "always"
+	pullPolicyIfNotPresent = "if-not-present"
+)
+
+// imagePresent reports whether the local daemon already holds the source
+// image. When the registry can be reached, the local copy must also match the
+// digest the source reference currently resolves to.
+func (s *syncer) imagePresent(ctx context.Context, cli *client.Client, source string) bool {
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, source)
+	if err != nil {
+		return false
+	}
+	ref, err := parseImageRef(source)
+	if err != nil {
+		return true
+	}
+	info, ok, err := s.registry.headManifest(ctx, ref)
+	if err != nil || !ok || info.Digest == "" {
+		s.logger.Warn("Failed to resolve digest, using local image", "image", source, "error", err)
+		return true
+	}
+	for _, d := range inspect.RepoDigests {
+		if strings.HasSuffix(d, "@"+info.Digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// immutableTagExists reports whether the target tag matches one of the
+// image's immutable patterns and already exists in the target registry.
+func (s *syncer) immutableTagExists(ctx context.Context, img *ImageConfig) (bool, error) {
+	if len(img.ImmutableTags) == 0 {
+		return false, nil
+	}
+	ref, err := parseImageRef(img.Target)
+	if err != nil {
+		return false, err
+	}
+	matched := false
+	for _, pattern := range img.ImmutableTags {
+		if ok, e := path.Match(pattern, ref.Reference); e != nil {
+			return false, fmt.Errorf("invalid immutable tag pattern %q: %w", pattern, e)
+		} else if ok {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false, nil
+	}
+	_, exists, err := s.registry.headManifest(ctx, ref)
+	if err != nil {
+		return false, fmt.Errorf("check target tag %s failed: %w", img.Target, err)
+	}
+	return exists, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// promLabelImage is the label holding the image reference in Prometheus
+// query results used as a priority source.
+const promLabelImage = "image"
+
+// fetchPullCounts reads image pull counts from a JSON endpoint returning
+// {"<image>": <count>} or from a Prometheus instant query URL whose result
+// series carry an "image" label.
+func fetchPullCounts(ctx context.Context, url string) (map[string]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch pull counts failed: %s", resp.Status)
+	}
+	var body map[string]json.RawMessage
+	if e := json.NewDecoder(resp.Body).Decode(&body); e != nil {
+		return nil, fmt.Errorf("decode pull counts failed: %w", e)
+	}
+
+	counts := make(map[string]float64)
+	data, isProm := body["data"]
+	if !isProm {
+		for image, raw := range body {
+			var n float64
+			if e := json.Unmarshal(raw, &n); e == nil {
+				counts[image] = n
+			}
+		}
+		return counts, nil
+	}
+	var prom struct {
+		Result []struct {
+			Metric map[string]string `json:"metric"`
+			Value  [2]any            `json:"value"`
+		} `json:"result"`
+	}
+	if e := json.Unmarshal(data, &prom); e != nil {
+		return nil, fmt.Errorf("decode prometheus result failed: %w", e)
+	}
+	for _, r := range prom.Result {
+		value, _ := r.Value[1].(string)
+		n, e := strconv.ParseFloat(value, 64)
+		if e != nil || r.Metric[promLabelImage] == "" {
+			continue
+		}
+		counts[r.Metric[promLabelImage]] += n
+	}
+	return counts, nil
+}
+
+// prioritizeImages sorts images by descending pull count, falling back to the
+// count of the repository when the tagged source has none. Without a priority
+// source or on error the order is unchanged.
+func (s *syncer) prioritizeImages(ctx context.Context, images []ImageConfig) []ImageConfig {
+	if s.config.PrioritySource == "" {
+		return images
+	}
+	counts, err := fetchPullCounts(ctx, s.config.PrioritySource)
+	if err != nil {
+		s.logger.Warn("Failed to fetch image pull counts, keeping config order", "error", err)
+		return images
+	}
+	count := func(img ImageConfig) float64 {
+		if n, ok := counts[img.Source]; ok {
+			return n
+		}
+		repo, _ := splitTag(img.Source)
+		return counts[repo]
+	}
+	sort.SliceStable(images, func(i, j int) bool {
+		return count(images[i]) > count(images[j])
+	})
+	return images
+}
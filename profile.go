@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startProfile starts a "cpu" or "mem" profile written to path and returns
+// the function finishing it.
+func startProfile(mode, path string, logger *slog.Logger) (func(), error) {
+	if mode != "cpu" && mode != "mem" {
+		return nil, fmt.Errorf("unsupported profile %q, use cpu or mem", mode)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create profile failed: %w", err)
+	}
+	if mode == "cpu" {
+		if e := pprof.StartCPUProfile(f); e != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("start cpu profile failed: %w", e)
+		}
+	}
+	return func() {
+		if mode == "cpu" {
+			pprof.StopCPUProfile()
+		} else {
+			runtime.GC()
+			if e := pprof.WriteHeapProfile(f); e != nil {
+				logger.Error("Failed to write heap profile", "path", path, "error", e)
+			}
+		}
+		if e := f.Close(); e != nil {
+			logger.Error("Failed to write profile", "path", path, "error", e)
+			return
+		}
+		logger.Info("Wrote profile", "profile", mode, "path", path)
+	}, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+const (
+	progressPending = "pending"
+	progressPulling = "pulling"
+	progressTagging = "tagging"
+	progressPushing = "pushing"
+	progressDone    = "done"
+	progressSkipped = "skipped"
+	progressError   = "error"
+
+	progressNameWidth = 48
+	progressBarWidth  = 24
+	progressInterval  = 200 * time.Millisecond
+)
+
+// progressDisplay draws a live table with one row per image of the current
+// cycle. It is also the log writer while active, so log lines are printed
+// above the table instead of breaking it. All methods are safe on a nil
+// display, which is used when stdout is not a terminal.
+type progressDisplay struct {
+	mu    sync.Mutex
+	out   io.Writer
+	rows  []*progressRow
+	index map[string]*progressRow
+	lines int
+}
+
+type progressRow struct {
+	image   string
+	status  string
+	start   time.Time
+	end     time.Time
+	layers  map[string]*jsonmessage.JSONProgress
+	current int64
+	total   int64
+}
+
+func newProgressDisplay(out io.Writer) *progressDisplay {
+	p := &progressDisplay{out: out, index: make(map[string]*progressRow)}
+	go func() {
+		for range time.Tick(progressInterval) {
+			p.mu.Lock()
+			p.redraw()
+			p.mu.Unlock()
+		}
+	}()
+	return p
+}
+
+// Write prints log output above the table.
+func (p *progressDisplay) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	n, err := p.out.Write(b)
+	p.redraw()
+	return n, err
+}
+
+// reset replaces the rows with the images of a new cycle.
+func (p *progressDisplay) reset(images []ImageConfig) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	p.rows = p.rows[:0]
+	p.index = make(map[string]*progressRow)
+	for _, img := range images {
+		row := &progressRow{image: img.Source, status: progressPending}
+		p.rows = append(p.rows, row)
+		p.index[img.Source] = row
+	}
+	p.redraw()
+}
+
+func (p *progressDisplay) setStatus(image, status string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	row, ok := p.index[image]
+	if !ok {
+		return
+	}
+	if row.start.IsZero() && status != progressPending {
+		row.start = time.Now()
+	}
+	if status == progressDone || status == progressSkipped || status == progressError {
+		row.end = time.Now()
+	}
+	if status != row.status {
+		row.layers, row.current, row.total = nil, 0, 0
+	}
+	row.status = status
+}
+
+// update returns a callback recording the layer progress of a pull or push
+// stream of image.
+func (p *progressDisplay) update(image string) func(jsonmessage.JSONMessage) {
+	if p == nil {
+		return nil
+	}
+	return func(msg jsonmessage.JSONMessage) {
+		if msg.Progress == nil || msg.ID == "" {
+			return
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		row, ok := p.index[image]
+		if !ok {
+			return
+		}
+		if row.layers == nil {
+			row.layers = make(map[string]*jsonmessage.JSONProgress)
+		}
+		row.layers[msg.ID] = msg.Progress
+		row.current, row.total = 0, 0
+		for _, l := range row.layers {
+			row.current += l.Current
+			row.total += l.Total
+		}
+	}
+}
+
+func (p *progressDisplay) clear() {
+	if p.lines > 0 {
+		fmt.Fprintf(p.out, "\x1b[%dA\x1b[J", p.lines)
+		p.lines = 0
+	}
+}
+
+func (p *progressDisplay) redraw() {
+	p.clear()
+	for _, row := range p.rows {
+		fmt.Fprintf(p.out, "%-*s %-8s %s %s\n", progressNameWidth, truncate(row.image, progressNameWidth), row.status, row.bar(), row.elapsed())
+	}
+	p.lines = len(p.rows)
+}
+
+func (r *progressRow) bar() string {
+	filled := 0
+	switch {
+	case r.status == progressDone || r.status == progressSkipped:
+		filled = progressBarWidth
+	case r.total > 0:
+		filled = int(min(r.current, r.total) * progressBarWidth / r.total)
+	}
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled) + "]"
+}
+
+func (r *progressRow) elapsed() string {
+	if r.start.IsZero() {
+		return ""
+	}
+	end := r.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return end.Sub(r.start).Round(time.Second).String()
+}
+
+func truncate(s string, width int) string {
+	if len(s) <= width {
+		return s
+	}
+	return "..." + s[len(s)-width+3:]
+}
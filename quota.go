@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+const bytesPerGB = 1 << 30
+
+// errQuotaLow is returned by a cycle skipped because a target registry is
+// low on quota, so it counts as a failed cycle.
+var errQuotaLow = errors.New("target registry is low on quota")
+
+// harborQuota is an entry of Harbor's /api/v2.0/quotas response. A hard
+// storage limit of -1 means unlimited.
+type harborQuota struct {
+	Ref struct {
+		Name string `json:"name"`
+	} `json:"ref"`
+	Hard struct {
+		Storage int64 `json:"storage"`
+	} `json:"hard"`
+	Used struct {
+		Storage int64 `json:"storage"`
+	} `json:"used"`
+}
+
+// checkFreeSpace reports whether every target project has at least
+// MinFreeSpaceGB of storage quota left. Registries without a Harbor quota
+// API are assumed to have enough space.
+func (s *syncer) checkFreeSpace(ctx context.Context) bool {
+	if s.config.MinFreeSpaceGB <= 0 {
+		return true
+	}
+	checked := make(map[string]bool)
+	for _, img := range s.config.Images {
+		target, err := resolveTarget(img.Source, img.Target, s.config.NamespaceMappings)
+		if err != nil {
+			continue
+		}
+		ref, err := parseImageRef(target)
+		if err != nil {
+			continue
+		}
+		project, _, _ := strings.Cut(ref.Repository, "/")
+		if checked[ref.Host+"/"+project] {
+			continue
+		}
+		checked[ref.Host+"/"+project] = true
+
+		free, ok, err := s.registry.harborFreeSpace(ctx, ref, project)
+		if err != nil {
+			s.logger.Debug("Quota check not supported", "registry", ref.Domain, "project", project, "error", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if gb := float64(free) / bytesPerGB; gb < s.config.MinFreeSpaceGB {
+			s.logger.Warn("Target registry is low on quota, skipping sync cycle",
+				"registry", ref.Domain, "project", project, "free_gb", gb, "min_free_space_gb", s.config.MinFreeSpaceGB)
+			return false
+		}
+	}
+	return true
+}
+
+// harborFreeSpace returns the storage left in the quota of a Harbor project.
+// ok is false when the project has no storage limit.
+func (c *registryClient) harborFreeSpace(ctx context.Context, ref *imageRef, project string) (int64, bool, error) {
+	u := c.scheme(ref) + ref.Host + "/api/v2.0/quotas?reference=project&page_size=100"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	if creds := c.credentials(ref); creds != nil && creds.Username != "" {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, false, fmt.Errorf("get quotas of %s failed: %s", ref.Domain, resp.Status)
+	}
+	var quotas []harborQuota
+	if e := json.NewDecoder(resp.Body).Decode(&quotas); e != nil {
+		return 0, false, fmt.Errorf("decode quotas of %s failed: %w", ref.Domain, e)
+	}
+	for _, q := range quotas {
+		if q.Ref.Name != project {
+			continue
+		}
+		if q.Hard.Storage < 0 {
+			return 0, false, nil
+		}
+		return q.Hard.Storage - q.Used.Storage, true, nil
+	}
+	return 0, false, nil
+}
+
+// lowOnDisk reports whether the Docker data root has less than
+// MinFreeSpaceGB free. A data root that is not on this host, as with a
+// remote daemon, is not checked and logs a warning once.
+func (s *syncer) lowOnDisk(ctx context.Context, cli *client.Client, img *ImageConfig) bool {
+	if s.config.MinFreeSpaceGB <= 0 {
+		return false
+	}
+	info, err := cli.Info(ctx)
+	if err != nil {
+		s.logger.Debug("Disk space check failed", "image", img.Source, "error", err)
+		return false
+	}
+	free, err := freeDiskSpace(info.DockerRootDir)
+	if err != nil {
+		s.diskCheckWarning.Do(func() {
+			s.logger.Warn("Host disk space check unavailable, min_free_space_gb only applies to target quotas", "path", info.DockerRootDir, "error", err)
+		})
+		return false
+	}
+	if gb := float64(free) / bytesPerGB; gb < s.config.MinFreeSpaceGB {
+		s.logger.Warn("docker data root is low on disk space, skip pull", "image", img.Source, "path", info.DockerRootDir, "free_gb", gb, "min_free_space_gb", s.config.MinFreeSpaceGB)
+		return true
+	}
+	return false
+}
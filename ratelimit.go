@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// PushRateLimit throttles pushes to a single target registry.
+type PushRateLimit struct {
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Burst             int     `json:"burst"`
+}
+
+// pushLimiter holds a token bucket per target registry hostname.
+type pushLimiter struct {
+	limiters map[string]*rate.Limiter
+	logger   *slog.Logger
+}
+
+func newPushLimiter(auths map[string]RegistryAuth, logger *slog.Logger) *pushLimiter {
+	p := &pushLimiter{limiters: make(map[string]*rate.Limiter), logger: logger}
+	for registry, auth := range auths {
+		limit := auth.PushRateLimit
+		if limit == nil || limit.RequestsPerSecond <= 0 {
+			continue
+		}
+		burst := limit.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		host, _, _ := strings.Cut(registry, "/")
+		p.limiters[host] = rate.NewLimiter(rate.Limit(limit.RequestsPerSecond), burst)
+	}
+	return p
+}
+
+// wait blocks until a push to the registry of ref is allowed or ctx is done.
+func (p *pushLimiter) wait(ctx context.Context, ref string) error {
+	if p == nil {
+		return nil
+	}
+	r, err := parseImageRef(ref)
+	if err != nil {
+		return nil
+	}
+	limiter, ok := p.limiters[r.Domain]
+	if !ok {
+		return nil
+	}
+	reservation := limiter.Reserve()
+	d := reservation.Delay()
+	if d <= 0 {
+		return nil
+	}
+	p.logger.Info("push is rate limited, waiting", "image", ref, "delay", d.Round(time.Millisecond))
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		reservation.Cancel()
+		return ctx.Err()
+	}
+}
+
+// pullSemaphore limits the concurrent pulls per source registry hostname.
+type pullSemaphore struct {
+	mu    sync.Mutex
+	limit int
+	slots map[string]chan struct{}
+}
+
+func newPullSemaphore(limit int) *pullSemaphore {
+	if limit < 1 {
+		return nil
+	}
+	return &pullSemaphore{limit: limit, slots: make(map[string]chan struct{})}
+}
+
+// acquire blocks until a pull from the registry of ref may start and returns
+// the function releasing it.
+func (p *pullSemaphore) acquire(ctx context.Context, ref string) (func(), error) {
+	registry := sourceRegistry(ref)
+	if p == nil || registry == "" {
+		return func() {}, nil
+	}
+	p.mu.Lock()
+	slots, ok := p.slots[registry]
+	if !ok {
+		slots = make(chan struct{}, p.limit)
+		p.slots[registry] = slots
+	}
+	p.mu.Unlock()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// attestationArtifactTypes are the referrer artifact types that carry
+// provenance and other in-toto attestations, usually wrapped in DSSE envelopes.
+var attestationArtifactTypes = []string{
+	"application/vnd.in-toto+json",
+	"application/vnd.dsse.envelope.v1+json",
+	"application/vnd.dev.sigstore.bundle.v0.3+json",
+}
+
+// syncReferrers copies the OCI referrers of the source image whose artifact
+// type is in artifactTypes to the target repository. An empty artifactTypes
+// copies every referrer.
+func (s *syncer) syncReferrers(ctx context.Context, img *ImageConfig, artifactTypes []string) error {
+	src, err := parseImageRef(img.Source)
+	if err != nil {
+		return err
+	}
+	dst, err := parseImageRef(img.Target)
+	if err != nil {
+		return err
+	}
+	srcInfo, ok, err := s.registry.headManifest(ctx, src)
+	if err != nil {
+		return fmt.Errorf("resolve digest of %s failed: %w", src, err)
+	}
+	if !ok || srcInfo.Digest == "" {
+		return fmt.Errorf("resolve digest of %s failed: manifest not found", src)
+	}
+	if dstInfo, found, e := s.registry.headManifest(ctx, dst); e == nil && found && dstInfo.Digest != srcInfo.Digest {
+		s.logger.Warn("target digest differs from source, copied referrers will not be attached to the target image", "target", dst.String(), "target_digest", dstInfo.Digest, "source_digest", srcInfo.Digest)
+	}
+
+	descriptors, err := s.registry.referrers(ctx, src, srcInfo.Digest, "")
+	if err != nil {
+		return err
+	}
+	copied := 0
+	for _, desc := range descriptors {
+		if len(artifactTypes) > 0 && !slices.Contains(artifactTypes, desc.ArtifactType) {
+			continue
+		}
+		digest := desc.Digest.String()
+		if e := s.registry.copyManifest(ctx, src.withReference(digest), dst.withReference(digest)); e != nil {
+			return fmt.Errorf("copy referrer %s (%s) failed: %w", digest, desc.ArtifactType, e)
+		}
+		copied++
+	}
+	s.logger.Info("copied referrers", "count", copied, "source", src.String(), "target", dst.String())
+	return nil
+}
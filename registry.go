@@ -0,0 +1,598 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerSchema1      = "application/vnd.docker.distribution.manifest.v1+json"
+	mediaTypeDockerSchema1Sig   = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+)
+
+// errStreamUnauthorized is returned by do when a request with a streamed
+// body was rejected with 401, which cannot be replayed.
+var errStreamUnauthorized = errors.New("unauthorized while streaming the request body")
+
+var manifestAcceptTypes = []string{
+	ocispec.MediaTypeImageManifest,
+	ocispec.MediaTypeImageIndex,
+	mediaTypeDockerManifest,
+	mediaTypeDockerManifestList,
+}
+
+// imageRef is an image reference split into the parts needed to talk to the
+// registry HTTP API.
+type imageRef struct {
+	Raw        string
+	Domain     string
+	Host       string
+	Repository string
+	Reference  string
+}
+
+func parseImageRef(s string) (*imageRef, error) {
+	named, err := reference.ParseNormalizedNamed(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image reference %s: %w", s, err)
+	}
+	named = reference.TagNameOnly(named)
+	ref := &imageRef{
+		Raw:        s,
+		Domain:     reference.Domain(named),
+		Repository: reference.Path(named),
+	}
+	ref.Host = ref.Domain
+	if ref.Host == "docker.io" {
+		ref.Host = "registry-1.docker.io"
+	}
+	if d, ok := named.(reference.Digested); ok {
+		ref.Reference = d.Digest().String()
+	} else if t, ok := named.(reference.Tagged); ok {
+		ref.Reference = t.Tag()
+	}
+	return ref, nil
+}
+
+// withReference returns a copy of the reference pointing at another tag or digest
+// in the same repository.
+func (r *imageRef) withReference(tagOrDigest string) *imageRef {
+	c := *r
+	c.Reference = tagOrDigest
+	return &c
+}
+
+func (r *imageRef) String() string {
+	sep := ":"
+	if strings.Contains(r.Reference, ":") {
+		sep = "@"
+	}
+	return r.Domain + "/" + r.Repository + sep + r.Reference
+}
+
+type manifestInfo struct {
+	MediaType string
+	Digest    string
+	Body      []byte
+}
+
+type registryClient struct {
+	http      *http.Client
+	auths     map[string]RegistryAuth
+	userAgent string
+
+	mu     sync.Mutex
+	tokens map[string]string
+
+	// bandwidth throttles blob copies, nil for no limit.
+	bandwidth *bandwidthLimiter
+}
+
+func newRegistryClient(config *Config) *registryClient {
+	return &registryClient{
+		http:      &http.Client{},
+		auths:     config.Auths,
+		userAgent: config.userAgent(),
+		tokens:    make(map[string]string),
+	}
+}
+
+func (c *registryClient) credentials(ref *imageRef) *registry.AuthConfig {
+	encoded := lookupAuth(c.auths, ref.Raw)
+	if encoded == "" {
+		encoded = lookupAuth(c.auths, ref.Domain)
+	}
+	if encoded == "" {
+		return nil
+	}
+	auth, err := registry.DecodeAuthConfig(encoded)
+	if err != nil {
+		return nil
+	}
+	return auth
+}
+
+// scheme returns "http://" for registries configured as insecure.
+func (c *registryClient) scheme(ref *imageRef) string {
+	if lookupRegistryAuth(c.auths, ref.Raw).Insecure || lookupRegistryAuth(c.auths, ref.Domain).Insecure {
+		return "http://"
+	}
+	return "https://"
+}
+
+func (c *registryClient) baseURL(ref *imageRef) string {
+	return c.scheme(ref) + ref.Host + "/v2/"
+}
+
+// ping reports whether the registry of ref answers the /v2/ API base
+// endpoint. An authentication challenge counts as healthy.
+func (c *registryClient) ping(ctx context.Context, ref *imageRef) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.baseURL(ref), nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return false
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusUnauthorized
+}
+
+// do sends a request to the registry, answering Basic and Bearer challenges
+// on a 401 response and retrying once.
+func (c *registryClient) do(ctx context.Context, ref *imageRef, req *http.Request, actions string) (*http.Response, error) {
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", c.userAgent)
+	scope := "repository:" + ref.Repository + ":" + actions
+	key := ref.Host + " " + scope
+
+	c.mu.Lock()
+	token := c.tokens[key]
+	c.mu.Unlock()
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	_ = readAllToDiscard(resp.Body)
+
+	token, err = c.authorize(ctx, ref, challenge, scope)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.tokens[key] = token
+	c.mu.Unlock()
+
+	// A streamed body was already consumed, so the caller has to start
+	// over with the refreshed token.
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return nil, fmt.Errorf("%s %s: %w", req.Method, ref.Domain, errStreamUnauthorized)
+	}
+	retry := req.Clone(ctx)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", token)
+	return c.http.Do(retry)
+}
+
+func (c *registryClient) authorize(ctx context.Context, ref *imageRef, challenge, scope string) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	creds := c.credentials(ref)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if creds == nil {
+			return "", fmt.Errorf("registry %s requires basic auth but no credentials are configured", ref.Domain)
+		}
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(creds.Username, creds.Password)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+		if creds != nil && creds.RegistryToken != "" {
+			return "Bearer " + creds.RegistryToken, nil
+		}
+		realm, err := url.Parse(params["realm"])
+		if err != nil || params["realm"] == "" {
+			return "", fmt.Errorf("invalid bearer realm from %s: %q", ref.Domain, params["realm"])
+		}
+		query := realm.Query()
+		if service := params["service"]; service != "" {
+			query.Set("service", service)
+		}
+		query.Set("scope", scope)
+		realm.RawQuery = query.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("User-Agent", c.userAgent)
+		if creds != nil && creds.Username != "" {
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("fetch token from %s failed: %w", realm.Host, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("fetch token from %s failed: %s", realm.Host, resp.Status)
+		}
+		var body struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if e := json.NewDecoder(resp.Body).Decode(&body); e != nil {
+			return "", fmt.Errorf("decode token from %s failed: %w", realm.Host, e)
+		}
+		if body.Token == "" {
+			body.Token = body.AccessToken
+		}
+		return "Bearer " + body.Token, nil
+	default:
+		return "", fmt.Errorf("unsupported auth challenge from %s: %q", ref.Domain, challenge)
+	}
+}
+
+// parseChallenge parses a WWW-Authenticate header such as
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`.
+func parseChallenge(header string) (string, map[string]string) {
+	params := make(map[string]string)
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, ", "), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key != "" {
+			params[strings.ToLower(strings.TrimSpace(key))] = value
+		}
+	}
+	return scheme, params
+}
+
+func registryError(op string, ref *imageRef, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s %s failed: %s %s", op, ref, resp.Status, strings.TrimSpace(string(body)))
+}
+
+func (c *registryClient) headManifest(ctx context.Context, ref *imageRef) (*manifestInfo, bool, error) {
+	req, err := http.NewRequest(http.MethodHead, c.baseURL(ref)+ref.Repository+"/manifests/"+ref.Reference, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestAcceptTypes, ", "))
+	resp, err := c.do(ctx, ref, req, "pull")
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, registryError("head manifest", ref, resp)
+	}
+	return &manifestInfo{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    resp.Header.Get("Docker-Content-Digest"),
+	}, true, nil
+}
+
+func (c *registryClient) getManifest(ctx context.Context, ref *imageRef, accept ...string) (*manifestInfo, error) {
+	if len(accept) == 0 {
+		accept = manifestAcceptTypes
+	}
+	req, err := http.NewRequest(http.MethodGet, c.baseURL(ref)+ref.Repository+"/manifests/"+ref.Reference, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(accept, ", "))
+	resp, err := c.do(ctx, ref, req, "pull")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, registryError("get manifest", ref, resp)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &manifestInfo{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    resp.Header.Get("Docker-Content-Digest"),
+		Body:      body,
+	}, nil
+}
+
+func (c *registryClient) putManifest(ctx context.Context, ref *imageRef, mediaType string, body []byte) (string, error) {
+	req, err := http.NewRequest(http.MethodPut, c.baseURL(ref)+ref.Repository+"/manifests/"+ref.Reference, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := c.do(ctx, ref, req, "pull,push")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", registryError("put manifest", ref, resp)
+	}
+	return resp.Header.Get("Docker-Content-Digest"), nil
+}
+
+// deleteManifest deletes the manifest with the given digest and every tag
+// pointing to it.
+func (c *registryClient) deleteManifest(ctx context.Context, ref *imageRef, digest string) error {
+	req, err := http.NewRequest(http.MethodDelete, c.baseURL(ref)+ref.Repository+"/manifests/"+digest, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, ref, req, "delete")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return registryError("delete manifest", ref, resp)
+	}
+	return nil
+}
+
+func (c *registryClient) blobExists(ctx context.Context, ref *imageRef, digest string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, c.baseURL(ref)+ref.Repository+"/blobs/"+digest, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(ctx, ref, req, "pull")
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, registryError("head blob "+digest, ref, resp)
+	}
+}
+
+func (c *registryClient) getBlob(ctx context.Context, ref *imageRef, digest string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL(ref)+ref.Repository+"/blobs/"+digest, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, ref, req, "pull")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, registryError("get blob "+digest, ref, resp)
+	}
+	return resp.Body, nil
+}
+
+// uploadBlob pushes a blob with a monolithic upload, streaming it from data.
+func (c *registryClient) uploadBlob(ctx context.Context, ref *imageRef, digest string, size int64, data io.Reader) error {
+	base := c.baseURL(ref)
+	req, err := http.NewRequest(http.MethodPost, base+ref.Repository+"/blobs/uploads/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, ref, req, "pull,push")
+	if err != nil {
+		return err
+	}
+	_ = readAllToDiscard(resp.Body)
+	if resp.StatusCode != http.StatusAccepted {
+		return registryError("start blob upload", ref, resp)
+	}
+	baseURL, _ := url.Parse(base)
+	location, err := baseURL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return fmt.Errorf("invalid upload location from %s: %w", ref.Domain, err)
+	}
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	req, err = http.NewRequest(http.MethodPut, location.String(), data)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err = c.do(ctx, ref, req, "pull,push")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return registryError("upload blob "+digest, ref, resp)
+	}
+	return nil
+}
+
+// checkPush starts a blob upload to the repository of ref and cancels it
+// right away, verifying push access without leaving anything behind.
+func (c *registryClient) checkPush(ctx context.Context, ref *imageRef) error {
+	base := c.baseURL(ref)
+	req, err := http.NewRequest(http.MethodPost, base+ref.Repository+"/blobs/uploads/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, ref, req, "pull,push")
+	if err != nil {
+		return err
+	}
+	_ = readAllToDiscard(resp.Body)
+	if resp.StatusCode != http.StatusAccepted {
+		return registryError("start blob upload", ref, resp)
+	}
+	baseURL, _ := url.Parse(base)
+	location, err := baseURL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return nil
+	}
+	if req, err = http.NewRequest(http.MethodDelete, location.String(), nil); err != nil {
+		return nil
+	}
+	if resp, err = c.do(ctx, ref, req, "pull,push"); err == nil {
+		_ = readAllToDiscard(resp.Body)
+	}
+	return nil
+}
+
+// copyBlob copies a blob between repositories unless the target already has it.
+func (c *registryClient) copyBlob(ctx context.Context, src, dst *imageRef, digest string, size int64) error {
+	if ok, err := c.blobExists(ctx, dst, digest); err != nil {
+		return err
+	} else if ok {
+		return nil
+	}
+	err := c.uploadBlobFrom(ctx, src, dst, digest, size)
+	if errors.Is(err, errStreamUnauthorized) {
+		// The token expired during the upload and was refreshed, so a new
+		// upload session streams the blob again.
+		err = c.uploadBlobFrom(ctx, src, dst, digest, size)
+	}
+	return err
+}
+
+// uploadBlobFrom streams a blob of src into a new upload session of dst.
+func (c *registryClient) uploadBlobFrom(ctx context.Context, src, dst *imageRef, digest string, size int64) error {
+	reader, err := c.getBlob(ctx, src, digest)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return c.uploadBlob(ctx, dst, digest, size, c.bandwidth.reader(ctx, reader))
+}
+
+// referrers lists the manifests whose subject is the given digest, optionally
+// filtered by artifact type. A registry without referrers support yields an error.
+func (c *registryClient) referrers(ctx context.Context, ref *imageRef, digest, artifactType string) ([]ocispec.Descriptor, error) {
+	endpoint := c.baseURL(ref) + ref.Repository + "/referrers/" + digest
+	if artifactType != "" {
+		endpoint += "?artifactType=" + url.QueryEscape(artifactType)
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ocispec.MediaTypeImageIndex)
+	resp, err := c.do(ctx, ref, req, "pull")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, registryError("list referrers", ref, resp)
+	}
+	var index ocispec.Index
+	if e := json.NewDecoder(resp.Body).Decode(&index); e != nil {
+		return nil, fmt.Errorf("decode referrers of %s failed: %w", ref, e)
+	}
+	return index.Manifests, nil
+}
+
+// copyManifest copies a single manifest and all blobs it references from src to dst.
+func (c *registryClient) copyManifest(ctx context.Context, src, dst *imageRef) error {
+	info, err := c.getManifest(ctx, src, ocispec.MediaTypeImageManifest, mediaTypeDockerManifest)
+	if err != nil {
+		return err
+	}
+	var manifest ocispec.Manifest
+	if e := json.Unmarshal(info.Body, &manifest); e != nil {
+		return fmt.Errorf("decode manifest %s failed: %w", src, e)
+	}
+	blobs := append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...)
+	for _, blob := range blobs {
+		if blob.Digest == "" {
+			continue
+		}
+		if e := c.copyBlob(ctx, src, dst, blob.Digest.String(), blob.Size); e != nil {
+			return e
+		}
+	}
+	mediaType := info.MediaType
+	if mediaType == "" {
+		mediaType = manifest.MediaType
+	}
+	_, err = c.putManifest(ctx, dst, mediaType, info.Body)
+	return err
+}
+
+// listTags returns all tags of the repository, following pagination links.
+func (c *registryClient) listTags(ctx context.Context, ref *imageRef) ([]string, error) {
+	base, _ := url.Parse(c.baseURL(ref))
+	next := base.String() + ref.Repository + "/tags/list"
+	var tags []string
+	for next != "" {
+		req, err := http.NewRequest(http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(ctx, ref, req, "pull")
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = registryError("list tags", ref, resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		var body struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode tags of %s failed: %w", ref, err)
+		}
+		tags = append(tags, body.Tags...)
+
+		next = ""
+		if link := resp.Header.Get("Link"); link != "" {
+			target, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(link), "<"), ">")
+			if u, e := base.Parse(target); e == nil {
+				next = u.String()
+			}
+		}
+	}
+	return tags, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+)
+
+// TestCopyBlobRestartsUploadOn401 expires the token during the first blob
+// PUT and expects a new upload session with the full blob.
+func TestCopyBlobRestartsUploadOn401(t *testing.T) {
+	const blob = "blob-data"
+	var sessions, puts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodGet:
+			_, _ = io.WriteString(w, blob)
+		case r.Method == http.MethodPost:
+			w.Header().Set("Location", fmt.Sprintf("/v2/dst/blobs/uploads/%d", sessions.Add(1)))
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			if puts.Add(1) == 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if string(body) != blob || r.Header.Get("Authorization") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	auth, err := registry.EncodeAuthConfig(registry.AuthConfig{Username: "user", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newRegistryClient(&Config{Auths: map[string]RegistryAuth{host: {Auth: auth, Insecure: true}}})
+	src, err := parseImageRef(host + "/src:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := parseImageRef(host + "/dst:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := c.copyBlob(context.Background(), src, dst, "sha256:0", int64(len(blob))); e != nil {
+		t.Fatalf("copyBlob: %v", e)
+	}
+	if n := sessions.Load(); n != 2 {
+		t.Errorf("started %d upload sessions, want 2", n)
+	}
+}
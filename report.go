@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// syncReport is the JSON report -report-file writes after each cycle.
+type syncReport struct {
+	StartedAt   time.Time     `json:"started_at"`
+	CompletedAt time.Time     `json:"completed_at"`
+	DurationMs  int64         `json:"duration_ms"`
+	Images      []imageReport `json:"images"`
+}
+
+type imageReport struct {
+	Source           string `json:"source"`
+	Target           string `json:"target"`
+	Status           string `json:"status"`
+	Error            string `json:"error,omitempty"`
+	PullDurationMs   int64  `json:"pull_duration_ms"`
+	PushDurationMs   int64  `json:"push_duration_ms"`
+	Digest           string `json:"digest,omitempty"`
+	BytesTransferred int64  `json:"bytes_transferred"`
+}
+
+// writeReport writes the results of the cycle started at start to path,
+// replacing the report of the previous cycle.
+func writeReport(path string, start time.Time, results []*imageResult) error {
+	now := time.Now()
+	report := syncReport{
+		StartedAt:   start,
+		CompletedAt: now,
+		DurationMs:  now.Sub(start).Milliseconds(),
+		Images:      make([]imageReport, 0, len(results)),
+	}
+	for _, r := range results {
+		entry := imageReport{
+			Source:           r.Source,
+			Target:           r.Target,
+			Status:           r.Status,
+			PullDurationMs:   r.PullDuration.Milliseconds(),
+			PushDurationMs:   r.PushDuration.Milliseconds(),
+			Digest:           r.Digest,
+			BytesTransferred: r.Bytes,
+		}
+		if r.Err != nil {
+			entry.Error = r.Err.Error()
+		}
+		report.Images = append(report.Images, entry)
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	defaultRequeueAttempts = 3
+	requeueDelay           = 30 * time.Second
+)
+
+// requeueFailed syncs the images that failed in this cycle again, waiting
+// requeueDelay before each pass so transient registry issues can resolve. It
+// returns the errors of the images still failing.
+func (s *syncer) requeueFailed(ctx context.Context, err error) error {
+	for attempt := 1; err != nil && attempt <= s.config.RequeueMaxAttempts; attempt++ {
+		results := s.results
+		var failed []ImageConfig
+		for _, r := range results {
+			if r != nil && r.Err != nil {
+				failed = append(failed, r.image)
+			}
+		}
+		if len(failed) == 0 {
+			return err
+		}
+		s.logger.Info("Requeueing failed images", "attempt", attempt, "images", len(failed), "delay", requeueDelay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(requeueDelay):
+		}
+		var requeued []*imageResult
+		requeued, err = s.syncImages(ctx, failed)
+		s.results = mergeResults(results, requeued)
+	}
+	return err
+}
+
+// mergeResults replaces the results of the images synced again by source and
+// target, since processImages reorders, filters and expands its input, and
+// appends the results of images that had none.
+func mergeResults(results, requeued []*imageResult) []*imageResult {
+	index := make(map[[2]string]int, len(results))
+	for i, r := range results {
+		if r != nil {
+			index[[2]string{r.Source, r.Target}] = i
+		}
+	}
+	for _, r := range requeued {
+		if r == nil {
+			continue
+		}
+		if i, ok := index[[2]string{r.Source, r.Target}]; ok {
+			results[i] = r
+		} else {
+			results = append(results, r)
+		}
+	}
+	return results
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMergeResults(t *testing.T) {
+	failed := errors.New("failed")
+	results := []*imageResult{
+		{Source: "a:1", Target: "x/a:1", Status: statusFailed, Err: failed},
+		{Source: "b:1", Target: "x/b:1", Status: statusSuccess},
+		{Source: "c:1", Target: "x/c:1", Status: statusFailed, Err: failed},
+	}
+	// processImages returns the requeued images in another order, drops
+	// one and expands another.
+	requeued := []*imageResult{
+		{Source: "c:1", Target: "x/c:1", Status: statusSuccess},
+		{Source: "d:2", Target: "x/d:2", Status: statusSuccess},
+	}
+	merged := mergeResults(results, requeued)
+
+	want := []struct {
+		source, status string
+	}{
+		{"a:1", statusFailed},
+		{"b:1", statusSuccess},
+		{"c:1", statusSuccess},
+		{"d:2", statusSuccess},
+	}
+	if len(merged) != len(want) {
+		t.Fatalf("got %d results, want %d", len(merged), len(want))
+	}
+	for i, w := range want {
+		if merged[i].Source != w.source || merged[i].Status != w.status {
+			t.Errorf("result %d = %s %s, want %s %s", i, merged[i].Source, merged[i].Status, w.source, w.status)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	statusSuccess = "success"
+	statusFailed  = "failed"
+	statusSkipped = "skipped"
+)
+
+// imageResult records the outcome of syncing a single image in a cycle.
+type imageResult struct {
+	Source       string
+	Target       string
+	Digest       string
+	Status       string
+	Err          error
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	PullDuration time.Duration
+	PushDuration time.Duration
+	Bytes        int64
+	Mirror       string
+
+	// CompressedSize is the compressed size of the source image, only
+	// measured for images with MaxSizeIncreasePct.
+	CompressedSize int64
+
+	// image is the config the target was resolved from, which
+	// requeueFailed syncs again.
+	image ImageConfig
+}
+
+// newImageResult starts the result of img, whose target was resolved from
+// configured.
+func newImageResult(img, configured *ImageConfig) *imageResult {
+	return &imageResult{
+		Source:    img.Source,
+		Target:    img.Target,
+		StartedAt: time.Now(),
+		image:     *configured,
+	}
+}
+
+func (r *imageResult) finish(err error) {
+	r.FinishedAt = time.Now()
+	r.Err = err
+	switch {
+	case err != nil:
+		r.Status = statusFailed
+	case r.Status == "":
+		r.Status = statusSuccess
+	}
+}
+
+// logTimeout logs which images completed before the cycle's transfer timeout
+// and which were cancelled by it.
+func (s *syncer) logTimeout(results []*imageResult) {
+	var completed, cancelled []string
+	for _, r := range results {
+		switch {
+		case r.Err == nil:
+			completed = append(completed, r.Source)
+		case errors.Is(r.Err, context.DeadlineExceeded), errors.Is(r.Err, context.Canceled):
+			cancelled = append(cancelled, r.Source)
+		}
+	}
+	s.logger.Error("Sync cycle timed out", "timeout", s.config.TransferTimeout, "completed", completed, "cancelled", cancelled)
+}
+
+// logSummary logs the totals of the cycle started at start.
+func (s *syncer) logSummary(start time.Time) {
+	var synced, failed int
+	var bytes int64
+	for _, r := range s.results {
+		switch r.Status {
+		case statusSuccess:
+			synced++
+			bytes += r.Bytes
+		case statusFailed:
+			failed++
+		}
+	}
+	s.logger.Info("Cycle complete", "synced", synced, "errors", failed, "bytes", bytes, "duration", time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	retryInitialBackoff = time.Second
+	retryMaxBackoff     = 5 * time.Minute
+)
+
+// retryWithBackoff calls fn until it succeeds, retrying with exponential
+// backoff up to maxRetries times. A positive maxDuration caps the total time
+// spent, even if retries remain. Without either limit fn runs once.
+func retryWithBackoff(ctx context.Context, logger *slog.Logger, name string, maxRetries int, maxDuration time.Duration, fn func() error) error {
+	start := time.Now()
+	backoff := retryInitialBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if maxRetries <= 0 && maxDuration <= 0 {
+			return err
+		}
+		if maxRetries > 0 && attempt >= maxRetries {
+			return fmt.Errorf("giving up on %s after %d retries: %w", name, attempt, err)
+		}
+		if maxDuration > 0 && time.Since(start)+backoff > maxDuration {
+			return fmt.Errorf("giving up on %s after %s: %w", name, time.Since(start).Round(time.Second), err)
+		}
+		logger.Warn("Retrying", "name", name, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, retryMaxBackoff)
+	}
+}
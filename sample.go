@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// errorSampler tracks the last error of each image across cycles to thin out
+// repeated identical errors in the logs.
+type errorSampler struct {
+	mu   sync.Mutex
+	last map[string]*sampledError
+}
+
+type sampledError struct {
+	message    string
+	repeats    int
+	suppressed int
+}
+
+// sample reports whether the error of image should be logged at rate, and
+// how many identical errors were suppressed since it was last logged.
+func (e *errorSampler) sample(image string, err error, rate float64) (bool, int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.last == nil {
+		e.last = make(map[string]*sampledError)
+	}
+	prev, ok := e.last[image]
+	if !ok || prev.message != err.Error() {
+		e.last[image] = &sampledError{message: err.Error()}
+		return true, 0
+	}
+	prev.repeats++
+	if rate <= 0 || rate >= 1 || math.Floor(float64(prev.repeats)*rate) > math.Floor(float64(prev.repeats-1)*rate) {
+		suppressed := prev.suppressed
+		prev.suppressed = 0
+		return true, suppressed
+	}
+	prev.suppressed++
+	return false, 0
+}
+
+// reset forgets the last error of image after it synced.
+func (e *errorSampler) reset(image string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.last, image)
+}
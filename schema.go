@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// isSchemaV1 reports whether a manifest is a deprecated Docker schema v1 manifest.
+func isSchemaV1(info *manifestInfo) bool {
+	switch info.MediaType {
+	case mediaTypeDockerSchema1, mediaTypeDockerSchema1Sig:
+		return true
+	}
+	var body struct {
+		SchemaVersion int `json:"schemaVersion"`
+	}
+	if json.Unmarshal(info.Body, &body) == nil {
+		return body.SchemaVersion == 1
+	}
+	return false
+}
+
+// checkManifestSchema inspects the source manifest before pulling and warns
+// about, or rejects, images only available as schema v1.
+func (s *syncer) checkManifestSchema(ctx context.Context, img *ImageConfig) error {
+	ref, err := parseImageRef(img.Source)
+	if err != nil {
+		return err
+	}
+	info, err := s.registry.getManifest(ctx, ref)
+	if err != nil {
+		s.logger.Warn("Failed to check manifest schema", "image", img.Source, "error", err)
+		return nil
+	}
+	if !isSchemaV1(info) {
+		return nil
+	}
+	if s.config.RejectSchemaV1 || s.config.StrictMode {
+		return fmt.Errorf("image %s only has a deprecated schema v1 manifest", img.Source)
+	}
+	s.logger.Warn("image only has a deprecated schema v1 manifest", "image", img.Source)
+	return nil
+}
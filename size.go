@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// maxPullSize returns the compressed size limit of an image, where the image
+// setting overrides the global one. Zero means unlimited.
+func (c *Config) maxPullSize(img *ImageConfig) int64 {
+	if img.MaxPullSize > 0 {
+		return img.MaxPullSize
+	}
+	return c.MaxPullSize
+}
+
+// exceedsMaxPullSize sums the compressed layer sizes of the source manifest,
+// across all platforms, and reports whether they exceed the limit.
+func (s *syncer) exceedsMaxPullSize(ctx context.Context, img *ImageConfig) (bool, int64, error) {
+	limit := s.config.maxPullSize(img)
+	if limit <= 0 {
+		return false, 0, nil
+	}
+	size, err := s.compressedSize(ctx, img)
+	if err != nil {
+		return false, 0, err
+	}
+	return size > limit, size, nil
+}
+
+// compressedSize sums the compressed layer sizes of the source manifest,
+// across all platforms.
+func (s *syncer) compressedSize(ctx context.Context, img *ImageConfig) (int64, error) {
+	ref, err := parseImageRef(img.Source)
+	if err != nil {
+		return 0, err
+	}
+	manifest, err := s.manifestLayers(ctx, ref)
+	if err != nil {
+		return 0, fmt.Errorf("read manifest of %s failed: %w", img.Source, err)
+	}
+	var size int64
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+	return size, nil
+}
+
+// checkSizeIncrease compares the compressed size of the pulled image with
+// the size recorded in the state file, and warns about, or in strict mode
+// rejects, growth beyond MaxSizeIncreasePct.
+func (s *syncer) checkSizeIncrease(ctx context.Context, img *ImageConfig, result *imageResult) error {
+	if img.MaxSizeIncreasePct <= 0 {
+		return nil
+	}
+	size, err := s.compressedSize(ctx, img)
+	if err != nil {
+		s.logger.Warn("Failed to check image size", "image", img.Source, "error", err)
+		return nil
+	}
+	result.CompressedSize = size
+	if !s.config.hasState() {
+		return nil
+	}
+	state, err := s.config.loadState()
+	if err != nil {
+		return err
+	}
+	entry, ok := state.Images[img.Source]
+	if !ok || entry.Size <= 0 {
+		return nil
+	}
+	increase := float64(size-entry.Size) / float64(entry.Size) * 100
+	if increase <= img.MaxSizeIncreasePct {
+		return nil
+	}
+	if s.config.StrictMode {
+		return fmt.Errorf("image %s grew by %.1f%% to %d bytes, more than max_size_increase_pct %g", img.Source, increase, size, img.MaxSizeIncreasePct)
+	}
+	s.logger.Warn("image size increased more than max_size_increase_pct", "image", img.Source, "bytes", size, "previous_bytes", entry.Size, "increase_pct", increase)
+	return nil
+}
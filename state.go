@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type ImageState struct {
+	Source       string    `json:"source"`
+	Target       string    `json:"target"`
+	Digest       string    `json:"digest,omitempty"`
+	LastStatus   string    `json:"last_status"`
+	LastError    string    `json:"last_error,omitempty"`
+	Mirror       string    `json:"mirror,omitempty"`
+	Size         int64     `json:"size,omitempty"`
+	LastSyncedAt time.Time `json:"last_synced_at,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// SyncState is persisted to Config.StateFile or etcd between cycles, keyed by source image.
+type SyncState struct {
+	Images map[string]*ImageState `json:"images"`
+}
+
+func loadState(path string) (*SyncState, error) {
+	state := &SyncState{Images: make(map[string]*ImageState)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+	if e := json.Unmarshal(data, state); e != nil {
+		return nil, fmt.Errorf("failed to parse state: %w", e)
+	}
+	if state.Images == nil {
+		state.Images = make(map[string]*ImageState)
+	}
+	return state, nil
+}
+
+// save writes the state atomically so an interrupted write never corrupts it.
+func (st *SyncState) save(path string) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if e := writeFileAtomic(path, data); e != nil {
+		return fmt.Errorf("failed to write state: %w", e)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data through a temporary file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Close()
+	} else {
+		_ = tmp.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// hasState reports whether the sync state is persisted, in etcd or the
+// state file.
+func (c *Config) hasState() bool {
+	return c.Etcd.enabled() || c.StateFile != ""
+}
+
+// loadState reads the sync state from etcd when configured, otherwise from
+// the state file.
+func (c *Config) loadState() (*SyncState, error) {
+	if c.Etcd.enabled() {
+		return loadEtcdState(c.Etcd)
+	}
+	return loadState(c.StateFile)
+}
+
+func (c *Config) saveState(state *SyncState) error {
+	if c.Etcd.enabled() {
+		return saveEtcdState(c.Etcd, state)
+	}
+	return state.save(c.StateFile)
+}
+
+// clearState resets the sync state so the next cycle starts from scratch.
+func (c *Config) clearState() error {
+	if c.Etcd.enabled() {
+		return clearEtcdState(c.Etcd)
+	}
+	if c.StateFile == "" {
+		return fmt.Errorf("state_file is not configured")
+	}
+	state := &SyncState{Images: make(map[string]*ImageState)}
+	return state.save(c.StateFile)
+}
+
+func (st *SyncState) update(r *imageResult) {
+	entry, ok := st.Images[r.Source]
+	if !ok {
+		entry = &ImageState{Source: r.Source}
+		st.Images[r.Source] = entry
+	}
+	entry.Target = r.Target
+	entry.LastStatus = r.Status
+	entry.UpdatedAt = r.FinishedAt
+	entry.LastError = ""
+	switch r.Status {
+	case statusSuccess:
+		entry.LastSyncedAt = r.FinishedAt
+		if r.Digest != "" {
+			entry.Digest = r.Digest
+		}
+		if r.Mirror != "" {
+			entry.Mirror = r.Mirror
+		}
+		if r.CompressedSize > 0 {
+			entry.Size = r.CompressedSize
+		}
+	case statusFailed:
+		entry.LastError = r.Err.Error()
+	}
+}
+
+// recordState merges results into the state file.
+func (s *syncer) recordState(results []*imageResult) error {
+	if !s.config.hasState() {
+		return nil
+	}
+	state, err := s.config.loadState()
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		state.update(r)
+	}
+	return s.config.saveState(state)
+}
+
+// pendingImages returns the images that failed or have no entry in the state
+// file. The state is keyed by the per-tag source, so images must already be
+// expanded.
+func (s *syncer) pendingImages(images []ImageConfig) []ImageConfig {
+	state, err := s.config.loadState()
+	if err != nil {
+		s.logger.Error("Failed to load state, syncing all images", "error", err)
+		return images
+	}
+	var pending []ImageConfig
+	for _, img := range images {
+		if entry, ok := state.Images[img.Source]; !ok || entry.LastStatus == statusFailed {
+			pending = append(pending, img)
+		}
+	}
+	if len(pending) == 0 {
+		s.logger.Info("No images pending since the last successful cycle", "total", len(images))
+		return nil
+	}
+	s.logger.Info("Syncing images that did not complete in the last cycle", "pending", len(pending), "total", len(images))
+	return pending
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	formatJSON = "json"
+	formatTOML = "toml"
+)
+
+// configFormat returns the format of the config at p, the one set in opts or
+// the one of its extension, ignoring .age and .gpg suffixes.
+func configFormat(p string, opts *loadOptions) string {
+	if opts != nil && opts.Format != "" {
+		return strings.ToLower(opts.Format)
+	}
+	if u, err := url.Parse(p); err == nil && u.Scheme != "" {
+		p = u.Path
+	}
+	p = strings.TrimSuffix(strings.TrimSuffix(p, ".age"), ".gpg")
+	p = strings.TrimSuffix(p, ".gz")
+	if path.Ext(p) == ".toml" {
+		return formatTOML
+	}
+	return formatJSON
+}
+
+// tomlToJSON converts a TOML config to JSON, so TOML keys are the JSON keys
+// of the config.
+func tomlToJSON(body []byte) ([]byte, error) {
+	var v map[string]any
+	if _, err := toml.NewDecoder(bytes.NewReader(body)).Decode(&v); err != nil {
+		return nil, fmt.Errorf("failed to parse toml config: %w", err)
+	}
+	return json.Marshal(v)
+}
+
+// jsonToTOML converts a JSON config to TOML, keeping integers as integers
+// and dropping null values, which TOML cannot express.
+func jsonToTOML(body []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v map[string]any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("failed to parse json config: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(tomlValue(v)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func tomlValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, item := range v {
+			if item != nil {
+				m[k] = tomlValue(item)
+			}
+		}
+		return m
+	case []any:
+		items := make([]any, 0, len(v))
+		for _, item := range v {
+			if item != nil {
+				items = append(items, tomlValue(item))
+			}
+		}
+		return items
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	default:
+		return v
+	}
+}
+
+// convertConfig writes the config at path in format to w.
+func convertConfig(path, format string, opts *loadOptions, w io.Writer) error {
+	body, err := readConfig(path, opts)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case formatTOML:
+		if body, err = jsonToTOML(body); err != nil {
+			return err
+		}
+	case formatJSON:
+		var buf bytes.Buffer
+		if e := json.Indent(&buf, body, "", "  "); e != nil {
+			return e
+		}
+		body = append(buf.Bytes(), '\n')
+	default:
+		return fmt.Errorf("unsupported config format %q", format)
+	}
+	_, err = w.Write(body)
+	return err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// validateTargets verifies that every target repository accepts pushes and
+// every archive directory is writable, logging each target that fails. It
+// reports whether all targets passed.
+func (s *syncer) validateTargets(ctx context.Context) bool {
+	ok := true
+	checked := make(map[string]bool)
+	for _, img := range s.resolvedImages(ctx) {
+		if path, archive := archivePath(img.Target); archive {
+			dir := filepath.Dir(path)
+			if checked[dir] {
+				continue
+			}
+			checked[dir] = true
+			if e := checkWritableDir(dir); e != nil {
+				ok = false
+				s.logger.Error("Target is not writable", "target", img.Target, "error", e)
+			}
+			continue
+		}
+		ref, err := parseImageRef(img.Target)
+		if err != nil {
+			ok = false
+			s.logger.Error("Invalid target", "target", img.Target, "error", err)
+			continue
+		}
+		repo := ref.Domain + "/" + ref.Repository
+		if checked[repo] {
+			continue
+		}
+		checked[repo] = true
+		if e := s.registry.checkPush(ctx, ref); e != nil {
+			ok = false
+			s.logger.Error("Target is not writable", "target", repo, "error", e)
+			continue
+		}
+		s.logger.Info("Target is writable", "target", repo)
+	}
+	return ok
+}
+
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".validate-*")
+	if err != nil {
+		return err
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/robfig/cron/v3"
+)
+
+// scheduleWarmUps returns a channel receiving the times of the cron
+// schedule spec. A time is dropped while the previous one is still pending.
+func scheduleWarmUps(spec string) (<-chan time.Time, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan time.Time, 1)
+	go func() {
+		for {
+			next := schedule.Next(time.Now())
+			time.Sleep(time.Until(next))
+			select {
+			case ch <- next:
+			default:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// warmUp pulls the images with WarmUp set into the local daemon without
+// pushing them, so the next sync only has to tag and push.
+func (s *syncer) warmUp(ctx context.Context) {
+	var images []ImageConfig
+	for _, img := range s.expandImages(ctx, s.config.Images) {
+		if img.WarmUp {
+			images = append(images, img)
+		}
+	}
+	if len(images) == 0 {
+		return
+	}
+	s.logger.Info("Warming up images", "images", len(images))
+	pulled := 0
+	for _, img := range images {
+		pull := image.PullOptions{All: true}
+		if s.config.Auths != nil {
+			pull.RegistryAuth = lookupAuth(s.config.Auths, img.Source)
+		}
+		if e := imageAuths(&img, &pull, &image.PushOptions{}); e != nil {
+			s.logger.Error("warm up image failed", "image", img.Source, "error", e)
+			continue
+		}
+		if s.lowOnDisk(ctx, s.cli, &img) {
+			continue
+		}
+		start := time.Now()
+		_, e := s.pullSource(ctx, s.cli, &img, &pull)
+		s.events.Publish(Event{Type: ImagePullCompleted, Image: img.Source, Start: start, Err: e})
+		if e != nil {
+			s.logger.Error("warm up image failed", "image", img.Source, "error", e)
+			continue
+		}
+		pulled++
+		s.logger.Info("warm up image success", "image", img.Source)
+	}
+	s.logger.Info("Warm up complete", "pulled", pulled, "errors", len(images)-pulled)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// configVersion identifies a remote config by its ETag, or its Last-Modified
+// time when the server sends no ETag.
+func configVersion(header http.Header) string {
+	if v := header.Get("ETag"); v != "" {
+		return v
+	}
+	return header.Get("Last-Modified")
+}
+
+// watchConfig checks the remote config at path every interval with a HEAD
+// request and signals on the returned channel when its version changed. It
+// returns nil when the config cannot be watched.
+func watchConfig(path string, interval time.Duration, opts *loadOptions, logger *slog.Logger) <-chan struct{} {
+	if interval <= 0 {
+		return nil
+	}
+	if !strings.HasPrefix(path, "http") {
+		logger.Warn("watch_config_interval only applies to HTTP configs", "config", path)
+		return nil
+	}
+	version := opts.version
+	if version == "" {
+		logger.Warn("Config server sends no ETag or Last-Modified header, not watching it", "config", path)
+		return nil
+	}
+	changes := make(chan struct{}, 1)
+	go func() {
+		for range time.Tick(interval) {
+			req, err := newConfigRequest(http.MethodHead, path, opts)
+			if err != nil {
+				logger.Error("Failed to check config", "error", err)
+				continue
+			}
+			resp, err := opts.httpClient().Do(req)
+			if err != nil {
+				logger.Error("Failed to check config", "error", err)
+				continue
+			}
+			_ = resp.Body.Close()
+			current := configVersion(resp.Header)
+			if resp.StatusCode != http.StatusOK || current == "" || current == version {
+				continue
+			}
+			logger.Info("Config changed", "config", path, "version", current)
+			version = current
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes
+}
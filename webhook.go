@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"path"
+	"time"
+)
+
+const webhookQueueSize = 100
+
+// registryEvent is an event of a Docker Registry notification envelope.
+type registryEvent struct {
+	Action string `json:"action"`
+	Target struct {
+		Repository string `json:"repository"`
+		Tag        string `json:"tag"`
+		Digest     string `json:"digest"`
+	} `json:"target"`
+	Request struct {
+		Host string `json:"host"`
+	} `json:"request"`
+}
+
+// startWebhookServer listens on addr for registry notifications sent with
+// token as bearer token and returns the push events it receives.
+func startWebhookServer(addr, token string, logger *slog.Logger) <-chan registryEvent {
+	events := make(chan registryEvent, webhookQueueSize)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var envelope struct {
+			Events []registryEvent `json:"events"`
+		}
+		if e := json.NewDecoder(r.Body).Decode(&envelope); e != nil {
+			http.Error(w, e.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, ev := range envelope.Events {
+			if ev.Action != "push" || ev.Target.Tag == "" {
+				continue
+			}
+			select {
+			case events <- ev:
+			default:
+				logger.Warn("Webhook queue is full, dropping event", "repository", ev.Target.Repository, "tag", ev.Target.Tag)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	go func() {
+		logger.Info("Listening for registry notifications", "addr", addr)
+		if e := http.ListenAndServe(addr, mux); e != nil {
+			logger.Error("Webhook server stopped", "error", e)
+		}
+	}()
+	return events
+}
+
+// sleep waits for d while syncing the images a webhook event reports as
+// pushed and warming up images on schedule. A nil events channel only sleeps.
+// It returns true early when changes reports a changed config.
+func (s *syncer) sleep(d time.Duration, events <-chan registryEvent, changes <-chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return false
+		case <-changes:
+			return true
+		case <-s.warmUps:
+			s.warmUp(context.Background())
+		case ev := <-events:
+			images := s.matchEvent(ev)
+			if len(images) == 0 {
+				continue
+			}
+			s.logger.Info("Syncing images after push event", "repository", ev.Target.Repository, "tag", ev.Target.Tag, "images", len(images))
+			// Webhook syncs keep their own results, apart from the cycle's.
+			results, e := s.syncImages(context.Background(), images)
+			if e != nil {
+				s.logger.Error("Error processing images", "error", e)
+			}
+			if e := s.recordState(results); e != nil {
+				s.logger.Error("Error saving state", "error", e)
+			}
+		}
+	}
+}
+
+// matchEvent returns the configured images whose source is the pushed
+// repository and tag, with glob tags narrowed to the pushed one.
+func (s *syncer) matchEvent(ev registryEvent) []ImageConfig {
+	var images []ImageConfig
+	for _, img := range s.config.Images {
+		if img.GHCROrg != "" || img.GHCRUser != "" {
+			continue
+		}
+		repo, pattern := splitTag(img.Source)
+		ref, err := parseImageRef(repo)
+		if err != nil || ref.Repository != ev.Target.Repository {
+			continue
+		}
+		if host := ev.Request.Host; host != "" && host != ref.Domain && host != ref.Host {
+			continue
+		}
+		if pattern == "" {
+			pattern = "latest"
+		}
+		if ok, _ := path.Match(pattern, ev.Target.Tag); !ok {
+			continue
+		}
+		item := img
+		item.Source = repo + ":" + ev.Target.Tag
+		if isTagGlob(pattern) {
+			item.Target = tagTarget(img.Target, ev.Target.Tag)
+		}
+		images = append(images, item)
+	}
+	return images
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// syncWindow reports whether now falls within the daily [notBefore, notAfter)
+// window and, if not, when the window opens next. Either bound may be empty,
+// and a window may wrap around midnight (e.g. 22:00 to 02:00).
+func syncWindow(now time.Time, notBefore, notAfter string) (bool, time.Time, error) {
+	if notBefore == "" && notAfter == "" {
+		return true, now, nil
+	}
+	start, end := time.Duration(0), 24*time.Hour
+	var err error
+	if notBefore != "" {
+		if start, err = parseTimeOfDay(notBefore); err != nil {
+			return false, now, err
+		}
+	}
+	if notAfter != "" {
+		if end, err = parseTimeOfDay(notAfter); err != nil {
+			return false, now, err
+		}
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+
+	var open bool
+	if start <= end {
+		open = offset >= start && offset < end
+	} else {
+		open = offset >= start || offset < end
+	}
+	if open {
+		return true, now, nil
+	}
+	next := midnight.Add(start)
+	if !next.After(now) {
+		next = midnight.AddDate(0, 0, 1).Add(start)
+	}
+	return false, next, nil
+}
+
+func (c *Config) location() *time.Location {
+	if c.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}